@@ -5,12 +5,14 @@ import (
 	"log"
 	"os"
 	"time"
+	_ "time/tzdata" // 内置时区数据，容器镜像中缺少系统时区库时也能加载配置的时区
 )
 
 // App 应用基础配置
 type App struct {
-	Name string `yaml:"name"` // 应用名称
-	Env  string `yaml:"env"`  // 运行环境（dev/test/prod）
+	Name     string `yaml:"name"`     // 应用名称
+	Env      string `yaml:"env"`      // 运行环境（dev/test/prod）
+	Timezone string `yaml:"timezone"` // 应用时区（如 Asia/Shanghai），按日期查询记录时以该时区的零点为界
 }
 
 // ServerConfig 服务器配置
@@ -19,6 +21,8 @@ type ServerConfig struct {
 	ReadTimeout  time.Duration `yaml:"read_timeout"`  // HTTP读取超时时间
 	WriteTimeout time.Duration `yaml:"write_timeout"` // HTTP写入超时时间
 	IdleTimeout  time.Duration `yaml:"idle_timeout"`  // 连接空闲超时时间
+
+	TrustedProxies []string `yaml:"trusted_proxies"` // 信任的反向代理地址（IP或CIDR），只采信这些地址转发的 X-Forwarded-For，为空时以连接的对端地址作为客户端IP
 }
 
 // DBConfig 数据库配置
@@ -55,6 +59,86 @@ type MinIOConfig struct {
 	MaxRetries int    `yaml:"max_retries"` // 最大重试次数
 }
 
+// LocalStorageConfig 本地磁盘存储配置
+type LocalStorageConfig struct {
+	Root          string `yaml:"root"`           // 存储根目录
+	PublicURL     string `yaml:"public_url"`     // 后端对外访问地址，用于生成下载链接（如 http://example.com:9003）
+	SigningSecret string `yaml:"signing_secret"` // 下载链接签名密钥（可由环境变量 FILE_TRANS_SIGNING_SECRET 覆盖）
+}
+
+// StorageConfig 存储后端配置
+type StorageConfig struct {
+	Type  string             `yaml:"type"`  // 存储后端类型（minio/local）
+	Local LocalStorageConfig `yaml:"local"` // 本地磁盘存储配置
+}
+
+// DownloadConfig 下载配置
+type DownloadConfig struct {
+	Mode        string `yaml:"mode"`         // 下载方式（presign：预签名直链，直接访问存储后端；proxy：由后端代理下载）
+	PublicURL   string `yaml:"public_url"`   // 代理下载时后端对外访问地址（如 http://example.com:9003），为空时返回相对路径
+	NotifyToken string `yaml:"notify_token"` // MinIO审计日志（audit webhook）的认证令牌，用于统计预签名直链的传输字节数，为空时不接收审计日志
+}
+
+// PickupConfig 取件码配置
+type PickupConfig struct {
+	Length     int    `yaml:"length"`      // 取件码长度
+	Alphabet   string `yaml:"alphabet"`    // 取件码字符集
+	MaxRetries int    `yaml:"max_retries"` // 生成取件码冲突时的最大重试次数
+}
+
+// UploadConfig 上传配置
+type UploadConfig struct {
+	ChunkSizeMB   int           `yaml:"chunk_size_mb"`  // 分片上传时每个分片的大小（MB，最小5MB）
+	SessionTTL    time.Duration `yaml:"session_ttl"`    // 分片上传会话有效期，超时未完成的上传会被清理
+	PresignExpiry time.Duration `yaml:"presign_expiry"` // 直传对象存储时预签名上传策略的有效期
+	MaxExpiry     time.Duration `yaml:"max_expiry"`     // 分享有效期上限，发送及调整有效期时校验
+
+	MaxFileSizeMB     int64    `yaml:"max_file_size_mb"`    // 单个文件大小上限（MB），对所有上传方式生效
+	MaxRequestSizeMB  int64    `yaml:"max_request_size_mb"` // 表单发送（sendPackage）的请求体大小上限（MB），解析表单前生效，大文件应使用分片上传
+	MaxTextSizeMB     int64    `yaml:"max_text_size_mb"`    // 文本内容大小上限（MB）
+	InlineTextMaxKB   int64    `yaml:"inline_text_max_kb"`  // 不超过该大小（KB）的文本直接保存在数据库中，取件时随响应返回，不写入存储
+	MaxFiles          int      `yaml:"max_files"`           // 单次发送的最大文件数
+	AllowedExtensions []string `yaml:"allowed_extensions"`  // 允许上传的文件扩展名（如 .pdf），为空时不限制
+	AllowedMimeTypes  []string `yaml:"allowed_mime_types"`  // 允许上传的MIME类型，支持 image/* 形式的通配，为空时不限制
+}
+
+// EncryptionConfig 存储加密配置
+type EncryptionConfig struct {
+	Enabled bool   `yaml:"enabled"` // 是否加密存储表单发送（sendPackage）的文件和文本，加密的分享只能通过代理下载
+	Secret  string `yaml:"secret"`  // 服务端密钥，与取件码共同派生包装数据密钥的密钥，不能与存储后端凭据放在一起（可由环境变量 FILE_TRANS_ENCRYPTION_SECRET 覆盖）
+}
+
+// SecurityConfig 安全配置
+type SecurityConfig struct {
+	PasswordMaxAttempts int           `yaml:"password_max_attempts"` // 分享密码最大连续错误次数
+	PasswordLockout     time.Duration `yaml:"password_lockout"`      // 密码错误次数达到上限后的锁定时长
+
+	RedeemWindow       time.Duration `yaml:"redeem_window"`        // 取件失败次数的统计窗口
+	RedeemMaxFailures  int           `yaml:"redeem_max_failures"`  // 单个IP在统计窗口内允许的最大取件失败次数
+	RedeemLockout      time.Duration `yaml:"redeem_lockout"`       // 单个IP首次被锁定的时长，之后每次锁定时长翻倍
+	RedeemMaxLockout   time.Duration `yaml:"redeem_max_lockout"`   // 单个IP锁定时长上限
+	GlobalMaxFailures  int           `yaml:"global_max_failures"`  // 全局在统计窗口内允许的最大取件失败次数
+	GlobalLockout      time.Duration `yaml:"global_lockout"`       // 全局失败次数超限后暂停取件的时长
+	EnumerationAlertAt int           `yaml:"enumeration_alert_at"` // 单个IP在统计窗口内尝试的不同取件码数达到该值时告警
+}
+
+// AuthConfig 用户认证配置
+type AuthConfig struct {
+	AllowAnonymous  bool          `yaml:"allow_anonymous"`   // 是否允许未登录用户发送文件
+	JWTSecret       string        `yaml:"jwt_secret"`        // JWT签名密钥（可由环境变量 FILE_TRANS_JWT_SECRET 覆盖）
+	Issuer          string        `yaml:"issuer"`            // JWT签发者
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl"`  // 访问令牌有效期
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"` // 刷新令牌有效期
+}
+
+// ReaperConfig 过期清理任务配置
+type ReaperConfig struct {
+	Enabled   bool          `yaml:"enabled"`    // 是否启用过期清理
+	Interval  time.Duration `yaml:"interval"`   // 扫描间隔
+	BatchSize int           `yaml:"batch_size"` // 每次扫描处理的最大记录数
+	LockTTL   time.Duration `yaml:"lock_ttl"`   // 分布式锁过期时间（多实例部署时只有持锁实例执行清理）
+}
+
 // LogFileConfig 日志文件配置（嵌套结构体）
 type LogFileConfig struct {
 	Path      string `yaml:"path"`       // 日志文件存储路径
@@ -73,16 +157,37 @@ type LogConfig struct {
 
 // Config 聚合所有配置
 type Config struct {
-	App    App          `yaml:"app"`    // 应用基础配置
-	Server ServerConfig `yaml:"server"` // 服务器配置
-	DB     DBConfig     `yaml:"db"`     // 数据库配置
-	Redis  RedisConfig  `yaml:"redis"`  // Redis配置
-	MinIO  MinIOConfig  `yaml:"minio"`  // MinIO配置
-	Log    LogConfig    `yaml:"log"`    // 日志配置
+	App        App              `yaml:"app"`        // 应用基础配置
+	Server     ServerConfig     `yaml:"server"`     // 服务器配置
+	DB         DBConfig         `yaml:"db"`         // 数据库配置
+	Redis      RedisConfig      `yaml:"redis"`      // Redis配置
+	MinIO      MinIOConfig      `yaml:"minio"`      // MinIO配置
+	Storage    StorageConfig    `yaml:"storage"`    // 存储后端配置
+	Download   DownloadConfig   `yaml:"download"`   // 下载配置
+	Pickup     PickupConfig     `yaml:"pickup"`     // 取件码配置
+	Upload     UploadConfig     `yaml:"upload"`     // 上传配置
+	Encryption EncryptionConfig `yaml:"encryption"` // 存储加密配置
+	Reaper     ReaperConfig     `yaml:"reaper"`     // 过期清理配置
+	Security   SecurityConfig   `yaml:"security"`   // 安全配置
+	Auth       AuthConfig       `yaml:"auth"`       // 用户认证配置
+	Log        LogConfig        `yaml:"log"`        // 日志配置
 }
 
 var AppConfig Config // 全局配置变量
 
+var Location = time.Local // 应用时区，由配置中的 app.timezone 加载
+
+// minSecretLength 签名密钥的最小长度
+const minSecretLength = 32
+
+// placeholderSecrets 示例配置中的占位密钥，不能直接使用
+var placeholderSecrets = map[string]bool{
+	"change_me_to_a_random_jwt_secret": true,
+	"your_jwt_secret_here":             true,
+	"change_me_to_a_random_secret":     true,
+	"your_signing_secret_here":         true,
+}
+
 func InitConfig(path string) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -93,5 +198,128 @@ func InitConfig(path string) {
 		log.Fatalf("解析配置文件失败：%v", err)
 	}
 
+	setDefaults(&AppConfig)
+
+	if AppConfig.Encryption.Enabled {
+		loadSecret(&AppConfig.Encryption.Secret, "FILE_TRANS_ENCRYPTION_SECRET", "encryption.secret")
+	}
+	loadSecret(&AppConfig.Auth.JWTSecret, "FILE_TRANS_JWT_SECRET", "auth.jwt_secret")
+	if AppConfig.Storage.Type == "local" {
+		loadSecret(&AppConfig.Storage.Local.SigningSecret, "FILE_TRANS_SIGNING_SECRET", "storage.local.signing_secret")
+	}
+
+	loc, err := time.LoadLocation(AppConfig.App.Timezone)
+	if err != nil {
+		log.Fatalf("加载时区失败：%v", err)
+	}
+	Location = loc
+
 	log.Println("配置文件加载成功")
 }
+
+// loadSecret 读取签名密钥，环境变量优先于配置文件；未配置、过短或仍为示例占位值时拒绝启动
+func loadSecret(secret *string, env, name string) {
+	if value := os.Getenv(env); value != "" {
+		*secret = value
+	}
+	if len(*secret) < minSecretLength || placeholderSecrets[*secret] {
+		log.Fatalf("%s 未配置、过短或仍为示例值，请通过配置文件或环境变量 %s 设置至少%d个字符的随机字符串", name, env, minSecretLength)
+	}
+}
+
+// setDefaults 为未配置的选项填充默认值
+func setDefaults(c *Config) {
+	if c.App.Timezone == "" {
+		c.App.Timezone = "Asia/Shanghai"
+	}
+	if c.Storage.Type == "" {
+		c.Storage.Type = "minio"
+	}
+	if c.Storage.Local.Root == "" {
+		c.Storage.Local.Root = "./data"
+	}
+	if c.Download.Mode == "" {
+		c.Download.Mode = "presign"
+	}
+	if c.Pickup.Length <= 0 {
+		c.Pickup.Length = 6
+	}
+	if c.Pickup.Alphabet == "" {
+		c.Pickup.Alphabet = "0123456789"
+	}
+	if c.Pickup.MaxRetries <= 0 {
+		c.Pickup.MaxRetries = 10
+	}
+	if c.Upload.ChunkSizeMB < 5 {
+		c.Upload.ChunkSizeMB = 5
+	}
+	if c.Upload.SessionTTL <= 0 {
+		c.Upload.SessionTTL = 24 * time.Hour
+	}
+	if c.Upload.PresignExpiry <= 0 {
+		c.Upload.PresignExpiry = time.Hour
+	}
+	if c.Upload.MaxExpiry <= 0 {
+		c.Upload.MaxExpiry = 30 * 24 * time.Hour
+	}
+	if c.Upload.MaxFileSizeMB <= 0 {
+		c.Upload.MaxFileSizeMB = 10240
+	}
+	if c.Upload.MaxRequestSizeMB <= 0 {
+		c.Upload.MaxRequestSizeMB = 1024
+	}
+	if c.Upload.MaxTextSizeMB <= 0 {
+		c.Upload.MaxTextSizeMB = 10
+	}
+	if c.Upload.InlineTextMaxKB <= 0 {
+		c.Upload.InlineTextMaxKB = 64
+	}
+	if c.Upload.MaxFiles <= 0 {
+		c.Upload.MaxFiles = 100
+	}
+	if c.Security.PasswordMaxAttempts <= 0 {
+		c.Security.PasswordMaxAttempts = 5
+	}
+	if c.Security.PasswordLockout <= 0 {
+		c.Security.PasswordLockout = 15 * time.Minute
+	}
+	if c.Security.RedeemWindow <= 0 {
+		c.Security.RedeemWindow = 10 * time.Minute
+	}
+	if c.Security.RedeemMaxFailures <= 0 {
+		c.Security.RedeemMaxFailures = 10
+	}
+	if c.Security.RedeemLockout <= 0 {
+		c.Security.RedeemLockout = time.Minute
+	}
+	if c.Security.RedeemMaxLockout <= 0 {
+		c.Security.RedeemMaxLockout = 24 * time.Hour
+	}
+	if c.Security.GlobalMaxFailures <= 0 {
+		c.Security.GlobalMaxFailures = 1000
+	}
+	if c.Security.GlobalLockout <= 0 {
+		c.Security.GlobalLockout = time.Minute
+	}
+	if c.Security.EnumerationAlertAt <= 0 {
+		c.Security.EnumerationAlertAt = 20
+	}
+	if c.Auth.Issuer == "" {
+		c.Auth.Issuer = c.App.Name
+	}
+	if c.Auth.AccessTokenTTL <= 0 {
+		c.Auth.AccessTokenTTL = 15 * time.Minute
+	}
+	if c.Auth.RefreshTokenTTL <= 0 {
+		c.Auth.RefreshTokenTTL = 7 * 24 * time.Hour
+	}
+	if c.Reaper.Interval <= 0 {
+		c.Reaper.Interval = time.Minute
+	}
+	if c.Reaper.BatchSize <= 0 {
+		c.Reaper.BatchSize = 100
+	}
+	if c.Reaper.LockTTL <= 0 {
+		c.Reaper.LockTTL = 5 * time.Minute
+	}
+}
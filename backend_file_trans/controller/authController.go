@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"daoke.com/file_trans/conf"
+	"daoke.com/file_trans/logger"
+	"daoke.com/file_trans/model"
+	"daoke.com/file_trans/service"
+	"daoke.com/file_trans/utils"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// contextUserKey 认证通过后当前用户在 gin.Context 中的键
+const contextUserKey = "currentUser"
+
+// 设备标识：由后端签发的不透明随机串，客户端保存后在请求头中回传，用于识别匿名用户
+const (
+	deviceIDHeader   = "X-Device-Id"
+	contextDeviceKey = "deviceHash"
+)
+
+// usernamePattern 用户名只允许字母、数字和下划线，长度3-32
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,32}$`)
+
+// 登录密码长度限制（bcrypt最多处理72字节）
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+type AuthController struct {
+	AuthService *service.AuthService
+}
+
+// NewAuthController 创建一个新的 AuthController 实例
+func NewAuthController() *AuthController {
+	return &AuthController{
+		AuthService: service.NewAuthService(),
+	}
+}
+
+// credentials 注册和登录的请求参数
+type credentials struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// refreshRequest 刷新和注销的请求参数
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// Register 注册用户
+func (a *AuthController) Register(ctx *gin.Context) {
+	var params credentials
+	if err := ctx.ShouldBindJSON(&params); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return
+	}
+	if !usernamePattern.MatchString(params.Username) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "用户名只能包含字母、数字和下划线，长度3-32位"})
+		return
+	}
+	if len(params.Password) < minPasswordLength || len(params.Password) > maxPasswordLength {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "密码长度必须为8-72位"})
+		return
+	}
+
+	user, err := a.AuthService.Register(params.Username, params.Password)
+	if err != nil {
+		if errors.Is(err, service.ErrUserExists) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "用户名已被注册"})
+			return
+		}
+		logger.Error("注册用户失败", "err", err, "username", params.Username)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "注册失败"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"msg": "注册成功", "userId": user.ID, "username": user.Username})
+}
+
+// Login 登录，返回访问令牌和刷新令牌
+func (a *AuthController) Login(ctx *gin.Context) {
+	var params credentials
+	if err := ctx.ShouldBindJSON(&params); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return
+	}
+
+	user, tokens, err := a.AuthService.Login(params.Username, params.Password)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
+			return
+		}
+		logger.Error("登录失败", "err", err, "username", params.Username)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "登录失败"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"userId":       user.ID,
+		"username":     user.Username,
+		"accessToken":  tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"tokenType":    tokens.TokenType,
+		"expiresIn":    tokens.ExpiresIn,
+	})
+}
+
+// Refresh 使用刷新令牌换取新的令牌
+func (a *AuthController) Refresh(ctx *gin.Context) {
+	var params refreshRequest
+	if err := ctx.ShouldBindJSON(&params); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return
+	}
+
+	tokens, err := a.AuthService.Refresh(params.RefreshToken)
+	if err != nil {
+		if errors.Is(err, service.ErrTokenInvalid) {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "刷新令牌无效或已过期，请重新登录"})
+			return
+		}
+		logger.Error("刷新令牌失败", "err", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "刷新令牌失败"})
+		return
+	}
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+// Logout 注销刷新令牌
+func (a *AuthController) Logout(ctx *gin.Context) {
+	var params refreshRequest
+	if err := ctx.ShouldBindJSON(&params); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return
+	}
+
+	if err := a.AuthService.Logout(params.RefreshToken); err != nil && !errors.Is(err, service.ErrTokenInvalid) {
+		logger.Error("注销失败", "err", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "注销失败"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"msg": "已退出登录"})
+}
+
+// Me 查询当前登录用户
+func (a *AuthController) Me(ctx *gin.Context) {
+	user := currentUser(ctx)
+	ctx.JSON(http.StatusOK, gin.H{"userId": user.ID, "username": user.Username})
+}
+
+// Authenticate 中间件：请求携带 Authorization: Bearer <token> 时校验令牌并将用户写入上下文，
+// 未携带时按匿名请求继续处理，令牌无效时返回401以便客户端刷新令牌
+func (a *AuthController) Authenticate(ctx *gin.Context) {
+	header := ctx.GetHeader("Authorization")
+	if header == "" {
+		ctx.Next()
+		return
+	}
+
+	tokenString, found := strings.CutPrefix(header, "Bearer ")
+	if !found {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization 格式应为 Bearer <token>"})
+		return
+	}
+	claims, err := a.AuthService.ParseToken(strings.TrimSpace(tokenString), service.TokenTypeAccess)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "登录已失效，请重新登录", "code": "TOKEN_INVALID"})
+		return
+	}
+
+	ctx.Set(contextUserKey, &model.User{ID: claims.UserID, Username: claims.Username})
+	ctx.Next()
+}
+
+// IdentifyDevice 中间件：校验请求头中的设备标识，缺失或格式不正确时签发新的标识，
+// 并通过响应头返回给客户端；上下文中只保存标识的哈希
+func (a *AuthController) IdentifyDevice(ctx *gin.Context) {
+	deviceID := ctx.GetHeader(deviceIDHeader)
+	if !utils.ValidateToken(deviceID) {
+		var err error
+		if deviceID, err = utils.GenerateToken(); err != nil {
+			logger.Error("生成设备标识失败", "err", err)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+	}
+	ctx.Header(deviceIDHeader, deviceID)
+	ctx.Set(contextDeviceKey, utils.HashToken(deviceID))
+	ctx.Next()
+}
+
+// RequireLogin 中间件：未登录时返回401
+func (a *AuthController) RequireLogin(ctx *gin.Context) {
+	if currentUser(ctx) == nil {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "请先登录", "code": "LOGIN_REQUIRED"})
+		return
+	}
+	ctx.Next()
+}
+
+// RequireSender 中间件：配置禁止匿名发送时，发送文件需要登录
+func (a *AuthController) RequireSender(ctx *gin.Context) {
+	if conf.AppConfig.Auth.AllowAnonymous {
+		ctx.Next()
+		return
+	}
+	a.RequireLogin(ctx)
+}
+
+// currentUser 获取当前登录用户，匿名请求返回nil
+func currentUser(ctx *gin.Context) *model.User {
+	if value, ok := ctx.Get(contextUserKey); ok {
+		if user, ok := value.(*model.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// currentCaller 获取当前调用方（登录用户ID及设备标识哈希）
+func currentCaller(ctx *gin.Context) service.Caller {
+	return service.Caller{
+		UserID:     currentUserID(ctx),
+		DeviceHash: ctx.GetString(contextDeviceKey),
+	}
+}
+
+// currentUserID 获取当前登录用户ID，匿名请求返回0
+func currentUserID(ctx *gin.Context) uint {
+	if user := currentUser(ctx); user != nil {
+		return user.ID
+	}
+	return 0
+}
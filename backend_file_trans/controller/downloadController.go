@@ -0,0 +1,235 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"daoke.com/file_trans/conf"
+	"daoke.com/file_trans/logger"
+	"daoke.com/file_trans/model"
+	"daoke.com/file_trans/service"
+	"daoke.com/file_trans/storage"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type DownloadController struct {
+	DownloadService *service.DownloadService
+}
+
+// NewDownloadController 创建一个新的 DownloadController 实例
+func NewDownloadController() *DownloadController {
+	return &DownloadController{
+		DownloadService: service.NewDownloadService(),
+	}
+}
+
+// Download 代理下载：校验下载令牌后由后端读取存储对象并返回，支持Range断点续传；
+// 加密存储的对象按块解密后返回；多文件分享边读取边打包为zip返回全部文件
+func (d *DownloadController) Download(ctx *gin.Context) {
+	token := ctx.Param("token")
+	transInfo, ok := d.resolve(ctx, token)
+	if !ok {
+		return
+	}
+	if transInfo.IsBundle() {
+		d.downloadBundle(ctx, token, transInfo)
+		return
+	}
+
+	object, info, ok := d.open(ctx, transInfo.StorageUrl, transInfo.DataKey)
+	if !ok {
+		return
+	}
+	defer object.Close()
+
+	// 优先使用上传时识别的内容类型（本地存储及分片上传的对象未保存内容类型）
+	if transInfo.MimeType != "" {
+		info.ContentType = transInfo.MimeType
+	}
+	setChecksumHeaders(ctx, transInfo.Sha256)
+	served := serveObject(ctx, object, info, transInfo.FileName)
+	d.DownloadService.RecordServed(ctx, token, info.Size, served, ctx.ClientIP(), ctx.Request.UserAgent())
+}
+
+// DownloadFile 代理下载多文件分享中的单个文件，支持Range断点续传
+func (d *DownloadController) DownloadFile(ctx *gin.Context) {
+	seq, err := strconv.Atoi(ctx.Param("seq"))
+	if err != nil || seq < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "文件序号无效"})
+		return
+	}
+	transInfo, ok := d.resolve(ctx, ctx.Param("token"))
+	if !ok {
+		return
+	}
+
+	file, err := d.DownloadService.BundleFile(transInfo, seq)
+	if err != nil {
+		d.abort(ctx, err)
+		return
+	}
+	object, info, ok := d.open(ctx, file.StorageUrl, transInfo.DataKey)
+	if !ok {
+		return
+	}
+	defer object.Close()
+
+	if file.MimeType != "" {
+		info.ContentType = file.MimeType
+	}
+	setChecksumHeaders(ctx, file.Sha256)
+
+	served := serveObject(ctx, object, info, file.FileName)
+	d.DownloadService.RecordServed(ctx, service.BundleFileTrackingID(ctx.Param("token"), seq), info.Size, served,
+		ctx.ClientIP(), ctx.Request.UserAgent())
+}
+
+// downloadBundle 边读取边打包返回多文件分享的全部文件，压缩包大小未知，不支持Range请求
+func (d *DownloadController) downloadBundle(ctx *gin.Context, token string, transInfo *model.TransInfo) {
+	ctx.Header("Content-Type", "application/zip")
+	ctx.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": transInfo.FileName}))
+	ctx.Header("Cache-Control", "private, no-store")
+	if ctx.Request.Method == http.MethodHead {
+		ctx.Status(http.StatusOK)
+		return
+	}
+
+	writer := &countingWriter{ResponseWriter: ctx.Writer}
+	if err := d.DownloadService.WriteBundle(ctx, transInfo, writer); err != nil {
+		// 响应头已发送，只能中断连接，客户端会得到不完整的压缩包
+		logger.Error("打包下载失败", "err", err, "fileUuid", transInfo.FileUuid, "written", writer.written)
+		ctx.Abort()
+		return
+	}
+	d.DownloadService.RecordServed(ctx, token, writer.written, writer.written, ctx.ClientIP(), ctx.Request.UserAgent())
+}
+
+// resolve 校验下载令牌，失败时直接写入错误响应
+func (d *DownloadController) resolve(ctx *gin.Context, token string) (*model.TransInfo, bool) {
+	transInfo, err := d.DownloadService.Resolve(ctx, token)
+	if err != nil {
+		d.abort(ctx, err)
+		return nil, false
+	}
+	return transInfo, true
+}
+
+// open 打开存储对象（加密存储的对象边读取边解密），失败时直接写入错误响应
+func (d *DownloadController) open(ctx *gin.Context, storageURL string, dataKey []byte) (io.ReadSeekCloser, storage.ObjectInfo, bool) {
+	object, info, err := d.DownloadService.Open(ctx, storageURL, dataKey)
+	if err != nil {
+		d.abort(ctx, err)
+		return nil, storage.ObjectInfo{}, false
+	}
+	return object, info, true
+}
+
+// abort 根据错误类型写入下载失败的响应
+func (d *DownloadController) abort(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrDownloadLinkInvalid):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "下载链接无效或已过期"})
+	case errors.Is(err, service.ErrShareRevoked):
+		ctx.JSON(http.StatusGone, gin.H{"error": "分享已撤销"})
+	default:
+		logger.Error("打开下载文件失败", "err", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "读取文件失败"})
+	}
+}
+
+// storageAuditEntry MinIO审计日志（audit webhook）中用到的字段
+type storageAuditEntry struct {
+	API struct {
+		Name       string `json:"name"`
+		StatusCode int    `json:"statusCode"`
+		TxBytes    int64  `json:"tx"` // 实际发送的响应体字节数
+	} `json:"api"`
+	RemoteHost   string            `json:"remotehost"`
+	UserAgent    string            `json:"userAgent"`
+	RequestQuery map[string]string `json:"requestQuery"`
+}
+
+// StorageEvents 接收MinIO审计日志（audit webhook），MinIO预签名直链下载不经过后端，
+// 按审计日志中附带跟踪标识的GET请求实际传输的字节数判定下载完成；后端自身读取存储对象的请求不带跟踪标识，不计入。
+// 请求体为单条或按行分隔的多条日志，请求头 Authorization 需携带配置的 download.notify_token
+func (d *DownloadController) StorageEvents(ctx *gin.Context) {
+	notifyToken := conf.AppConfig.Download.NotifyToken
+	token := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(notifyToken)) != 1 {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "认证失败"})
+		return
+	}
+
+	decoder := json.NewDecoder(ctx.Request.Body)
+	for {
+		var entry storageAuditEntry
+		if err := decoder.Decode(&entry); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+			return
+		}
+
+		trackingID := entry.RequestQuery[storage.TrackQueryParam]
+		if trackingID == "" || entry.API.Name != "GetObject" ||
+			(entry.API.StatusCode != http.StatusOK && entry.API.StatusCode != http.StatusPartialContent) {
+			continue
+		}
+		d.DownloadService.RecordStorageAccess(ctx, trackingID, entry.API.TxBytes, entry.RemoteHost, entry.UserAgent)
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// setChecksumHeaders 返回上传时记录的完整文件的SHA-256（与Range无关），供客户端下载后校验：
+// X-Checksum-Sha256 为十六进制，Repr-Digest 为 RFC 9530 格式
+func setChecksumHeaders(ctx *gin.Context, checksum string) {
+	sum, err := hex.DecodeString(checksum)
+	if err != nil || len(sum) != sha256.Size {
+		return
+	}
+	ctx.Header("X-Checksum-Sha256", checksum)
+	ctx.Header("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum)+":")
+}
+
+// countingWriter 统计写入响应体的字节数
+type countingWriter struct {
+	gin.ResponseWriter
+	written int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// serveObject 返回存储对象，设置 Content-Disposition、ETag 等响应头，返回实际传输的字节数；
+// Range、If-Range、If-None-Match 等条件请求由 http.ServeContent 处理
+func serveObject(ctx *gin.Context, object io.ReadSeeker, info storage.ObjectInfo, fileName string) int64 {
+	if info.ContentType != "" {
+		ctx.Header("Content-Type", info.ContentType)
+	}
+	if info.ETag != "" {
+		// ServeContent 按 RFC 7232 比较 ETag，要求带双引号
+		etag := info.ETag
+		if !strings.HasPrefix(etag, `"`) && !strings.HasPrefix(etag, `W/"`) {
+			etag = strconv.Quote(etag)
+		}
+		ctx.Header("ETag", etag)
+	}
+	// 非ASCII文件名按 RFC 2231 编码为 filename*
+	ctx.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	ctx.Header("Cache-Control", "private, no-store")
+	writer := &countingWriter{ResponseWriter: ctx.Writer}
+	http.ServeContent(writer, ctx.Request, fileName, info.LastModified, object)
+	return writer.written
+}
@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"daoke.com/file_trans/logger"
+	"daoke.com/file_trans/model"
+	"daoke.com/file_trans/service"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// manageTokenHeader 携带管理令牌的请求头
+const manageTokenHeader = "X-Manage-Token"
+
+type ManageController struct {
+	ManageService        *service.ManageService
+	DownloadEventService *service.DownloadEventService
+}
+
+// NewManageController 创建一个新的 ManageController 实例
+func NewManageController() *ManageController {
+	return &ManageController{
+		ManageService:        service.NewManageService(),
+		DownloadEventService: service.NewDownloadEventService(),
+	}
+}
+
+// GetShare 查看分享信息及下载统计
+func (m *ManageController) GetShare(ctx *gin.Context) {
+	transInfo, ok := m.authorize(ctx)
+	if !ok {
+		return
+	}
+
+	stats, err := m.ManageService.Stats(transInfo)
+	if err != nil {
+		logger.Error("查询分享统计失败", "err", err, "fileUuid", transInfo.FileUuid)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "查询分享统计失败"})
+		return
+	}
+	ctx.JSON(http.StatusOK, stats)
+}
+
+// RevokeShare 撤销分享，取件码立即失效并删除文件
+func (m *ManageController) RevokeShare(ctx *gin.Context) {
+	transInfo, ok := m.authorize(ctx)
+	if !ok {
+		return
+	}
+
+	if err := m.ManageService.Revoke(transInfo); err != nil {
+		logger.Error("撤销分享失败", "err", err, "fileUuid", transInfo.FileUuid)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "撤销分享失败"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"msg": "分享已撤销"})
+}
+
+// UpdateExpiry 调整分享有效期，新的过期时间从当前时间起算（参数同发送时的 expireTip、expireUnit）
+func (m *ManageController) UpdateExpiry(ctx *gin.Context) {
+	transInfo, ok := m.authorize(ctx)
+	if !ok {
+		return
+	}
+
+	expiry, expiresIn, expireUnit, ok := parseExpiry(ctx)
+	if !ok {
+		return
+	}
+
+	expireAt, err := m.ManageService.UpdateExpiry(transInfo, expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrShareRevoked):
+			ctx.JSON(http.StatusGone, gin.H{"error": "分享已撤销"})
+		case errors.Is(err, service.ErrPickupCodeExpired):
+			ctx.JSON(http.StatusGone, gin.H{"error": "分享已过期或下载次数已用尽"})
+		default:
+			logger.Error("调整分享有效期失败", "err", err, "fileUuid", transInfo.FileUuid)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "调整分享有效期失败"})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"msg":       "有效期已更新",
+		"expireAt":  expireAt,
+		"expiresIn": expiresIn,
+		"unit":      expireUnit,
+	})
+}
+
+// QueryDownloadEvents 查询分享的下载事件，供发送者查看谁在何时取件
+func (m *ManageController) QueryDownloadEvents(ctx *gin.Context) {
+	transInfo, ok := m.authorize(ctx)
+	if !ok {
+		return
+	}
+
+	events, counts, err := m.DownloadEventService.QueryEvents(transInfo.FileUuid)
+	if err != nil {
+		logger.Error("查询下载事件失败", "err", err, "fileUuid", transInfo.FileUuid)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "查询下载事件失败"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"events": events, "counts": counts})
+}
+
+// authorize 校验请求头中的管理令牌或当前登录用户是否为分享所有者，失败时直接写入错误响应
+func (m *ManageController) authorize(ctx *gin.Context) (*model.TransInfo, bool) {
+	token := ctx.GetHeader(manageTokenHeader)
+	userID := currentUserID(ctx)
+	if token == "" && userID == 0 {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "请在请求头 " + manageTokenHeader + " 中传入管理令牌"})
+		return nil, false
+	}
+
+	transInfo, err := m.ManageService.Authorize(ctx.Param("fileUuid"), token, userID)
+	if err != nil {
+		if errors.Is(err, service.ErrManageTokenInvalid) {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "分享不存在或管理令牌无效"})
+			return nil, false
+		}
+		logger.Error("校验管理令牌失败", "err", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "校验管理令牌失败"})
+		return nil, false
+	}
+	return transInfo, true
+}
@@ -1,92 +1,253 @@
 package controller
 
 import (
+	"daoke.com/file_trans/conf"
+	"daoke.com/file_trans/logger"
+	"daoke.com/file_trans/model"
 	"daoke.com/file_trans/service"
+	"daoke.com/file_trans/utils"
+	"encoding/hex"
+	"errors"
 	"github.com/gin-gonic/gin"
+	"math"
 	"net/http"
-	"regexp"
+	"strconv"
+	"time"
 )
 
 type ReceiveController struct {
-	ReceiveService *service.ReceiveService
+	ReceiveService       *service.ReceiveService
+	GuardService         *service.GuardService
+	DownloadEventService *service.DownloadEventService
 }
 
 func NewReceiveController() *ReceiveController {
 	return &ReceiveController{
-		ReceiveService: service.NewReceiveService(),
+		ReceiveService:       service.NewReceiveService(),
+		GuardService:         service.NewGuardService(),
+		DownloadEventService: service.NewDownloadEventService(),
 	}
 }
 
+// Receive 凭取件码取件，返回下载链接及文件清单；内联保存的文本直接在 content 中返回
 func (r *ReceiveController) Receive(ctx *gin.Context) {
 	// 从请求参数中获取取件码
-	pickupCode := ctx.Query("pickupCode")
+	transInfo, ok := r.redeem(ctx, ctx.Query("pickupCode"))
+	if !ok {
+		return
+	}
+
+	// 返回文件下载链接及文件清单（多文件分享的 fileDownloadUrl 为打包下载全部文件的链接，内联文本没有下载链接）
+	response := gin.H{
+		"fileName":        transInfo.FileName,
+		"fileUuid":        transInfo.FileUuid,
+		"fileSize":        transInfo.FileSize,
+		"fileCount":       transInfo.FileCount,
+		"mimeType":        transInfo.MimeType,
+		"category":        transInfo.Category,
+		"sha256":          transInfo.Sha256,
+		"expired":         transInfo.IsExpire,
+		"maxDownloads":    transInfo.MaxDownloads,
+		"downloadCount":   transInfo.DownloadCount,
+		"encrypted":       transInfo.IsEncrypted(),
+		"type":            transInfo.FileType,
+		"encryptedMeta":   transInfo.EncryptedMeta, // 端到端加密分享（type 为 e2e）的加密元数据，由客户端用链接中的密钥解密
+		"language":        transInfo.Language,      // 文本的语言提示，用于语法高亮
+		"fileDownloadUrl": transInfo.StorageUrl,
+		"downloadToken":   transInfo.DownloadToken, // 校验下载文件时使用，与下载链接同时失效
+		"files":           service.NewManifest(transInfo),
+	}
+	if transInfo.IsInline() {
+		response["content"] = transInfo.TextContent
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// Raw 以 text/plain 返回文本分享的内容，便于 curl 等命令行工具取件（分享密码通过请求头 X-Share-Password 或查询参数 password 传入）；
+// 写入存储的文本及文件分享重定向到下载链接（curl -L 跟随重定向）
+func (r *ReceiveController) Raw(ctx *gin.Context) {
+	transInfo, ok := r.redeem(ctx, ctx.Param("code"))
+	if !ok {
+		return
+	}
+	if !transInfo.IsInline() {
+		ctx.Redirect(http.StatusFound, transInfo.StorageUrl)
+		return
+	}
+
+	ctx.Header("Cache-Control", "private, no-store")
+	ctx.Header("X-Content-Type-Options", "nosniff")
+	ctx.Data(http.StatusOK, service.TextContentType, []byte(transInfo.TextContent))
+}
+
+// redeem 校验并兑换取件码，失败时记录下载事件并直接写入错误响应
+func (r *ReceiveController) redeem(ctx *gin.Context, pickupCode string) (*model.TransInfo, bool) {
 	if pickupCode == "" {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请输入取件码！"})
-		return
+		return nil, false
+	}
+
+	// 防爆破：客户端IP或全局处于锁定期时直接拒绝
+	clientIP := ctx.ClientIP()
+	lockout, err := r.GuardService.CheckLocked(clientIP)
+	if err != nil {
+		logger.Error("检查取件锁定状态失败", "err", err, "client_ip", clientIP)
+	}
+	if lockout > 0 {
+		r.recordEvent(ctx, "", model.OutcomeRateLimited)
+		tooManyRequests(ctx, lockout)
+		return nil, false
 	}
 
-	// 验证取件码格式（6位数字）
-	match, _ := regexp.MatchString(`^\d{6}$`, pickupCode)
-	if !match {
+	// 验证取件码格式（长度和字符集由配置决定）
+	pickupConf := conf.AppConfig.Pickup
+	if !utils.ValidatePickupCode(pickupCode, pickupConf.Length, pickupConf.Alphabet) {
+		r.recordFailure(ctx, pickupCode)
+		r.recordEvent(ctx, "", model.OutcomeInvalidCode)
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请输入正确取件码！"})
-		return
+		return nil, false
 	}
 
 	// 调用服务层方法查询文件传输信息
-	transInfo, err := r.ReceiveService.GetTransInfoByPickupCode(pickupCode)
+	// 分享密码优先从请求头获取，避免出现在访问日志中
+	password := ctx.GetHeader("X-Share-Password")
+	if password == "" {
+		password = ctx.Query("password")
+	}
+
+	transInfo, err := r.ReceiveService.GetTransInfoByPickupCode(pickupCode, password)
 	if err != nil {
-		// 区分不同错误类型返回对应信息
-		switch err.Error() {
-		case "pickupCode is expired", "pickupCode not found":
+		// 分享存在但被拒绝时记录到对应分享下
+		fileUUID := ""
+		if transInfo != nil {
+			fileUUID = transInfo.FileUuid
+		}
+
+		// 区分不同错误类型返回对应信息，code 供前端区分是否需要提示输入密码
+		switch {
+		case errors.Is(err, service.ErrPickupCodeNotFound):
+			r.recordFailure(ctx, pickupCode)
+			r.recordEvent(ctx, fileUUID, model.OutcomeNotFound)
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "取件码已过期或不存在！"})
+		case errors.Is(err, service.ErrPickupCodeExpired):
+			r.recordFailure(ctx, pickupCode)
+			r.recordEvent(ctx, fileUUID, model.OutcomeExpired)
 			ctx.JSON(http.StatusNotFound, gin.H{"error": "取件码已过期或不存在！"})
+		case errors.Is(err, service.ErrPasswordRequired):
+			r.recordEvent(ctx, fileUUID, model.OutcomePasswordRequired)
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "该分享需要密码", "code": "PASSWORD_REQUIRED"})
+		case errors.Is(err, service.ErrPasswordIncorrect):
+			r.recordEvent(ctx, fileUUID, model.OutcomePasswordIncorrect)
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "分享密码错误", "code": "PASSWORD_INCORRECT"})
+		case errors.Is(err, service.ErrPasswordLocked):
+			r.recordEvent(ctx, fileUUID, model.OutcomePasswordLocked)
+			ctx.JSON(http.StatusTooManyRequests, gin.H{"error": "密码错误次数过多，请稍后再试", "code": "PASSWORD_LOCKED"})
 		default:
+			logger.Error("取件失败", "err", err)
+			r.recordEvent(ctx, fileUUID, model.OutcomeError)
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 		}
-		return
+		return nil, false
 	}
 
-	// 返回文件下载链接
-	ctx.JSON(http.StatusOK, gin.H{
-		"fileName":        transInfo.FileName,
-		"fileUuid":        transInfo.FileUuid,
-		"fileSize":        transInfo.FileSize,
-		"expired":         transInfo.IsExpire,
-		"fileDownloadUrl": transInfo.StorageUrl,
-	})
-}
+	r.recordEvent(ctx, transInfo.FileUuid, model.OutcomeSuccess)
 
-// UpdateFileStatus 处理文件下载完成后的状态更新
-func (r *ReceiveController) UpdateFileStatus(ctx *gin.Context) {
-	// 定义接收JSON参数的结构体
-	type req struct {
-		FileUuid string `json:"fileUuid" binding:"required"`
+	// 内联文本随响应返回，即视为下载完成
+	if transInfo.IsInline() {
+		r.DownloadEventService.Record(&model.DownloadEvent{
+			FileUuid:    transInfo.FileUuid,
+			ClientIP:    clientIP,
+			UserAgent:   ctx.Request.UserAgent(),
+			BytesServed: transInfo.FileSize,
+			Outcome:     model.OutcomeCompleted,
+		})
 	}
-	var params req
+	return transInfo, true
+}
 
-	// 绑定JSON请求体到结构体
-	if err := ctx.ShouldBindJSON(&params); err != nil {
-		// 绑定失败（如缺少fileUuid、格式错误），返回http.StatusBadRequest错误并提示原因
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+// VerifyChecksum 凭取件响应中的 downloadToken 校验下载文件的SHA-256（查询参数 sha256 为客户端计算的十六进制值，
+// 多文件分享通过 seq 指定文件），只返回是否一致
+func (r *ReceiveController) VerifyChecksum(ctx *gin.Context) {
+	checksum := ctx.Query("sha256")
+	if len(checksum) != 64 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请传入64位十六进制的sha256"})
+		return
+	}
+	if _, err := hex.DecodeString(checksum); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请传入64位十六进制的sha256"})
+		return
+	}
+	seq, err := strconv.Atoi(ctx.DefaultQuery("seq", "0"))
+	if err != nil || seq < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "文件序号seq无效"})
 		return
 	}
 
-	err := r.ReceiveService.UpDateFileStatus(params.FileUuid)
+	match, err := r.ReceiveService.VerifyChecksum(ctx, ctx.Param("token"), seq, checksum)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "文件状态更新失败"})
+		switch {
+		case errors.Is(err, service.ErrDownloadLinkInvalid):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "下载链接无效或已过期"})
+		case errors.Is(err, service.ErrShareRevoked):
+			ctx.JSON(http.StatusGone, gin.H{"error": "分享已撤销"})
+		case errors.Is(err, service.ErrShareNotFound):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+		case errors.Is(err, service.ErrChecksumUnavailable):
+			ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": "该文件没有记录校验值"})
+		default:
+			logger.Error("校验文件失败", "err", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "校验文件失败"})
+		}
 		return
 	}
+	ctx.JSON(http.StatusOK, gin.H{"match": match, "algorithm": "sha256"})
+}
+
+// recordEvent 记录下载事件（预签名直链下载无法得知传输字节数）
+func (r *ReceiveController) recordEvent(ctx *gin.Context, fileUUID, outcome string) {
+	caller := currentCaller(ctx)
+	r.DownloadEventService.Record(&model.DownloadEvent{
+		FileUuid:   fileUUID,
+		UserID:     caller.UserID,
+		DeviceHash: caller.DeviceHash,
+		ClientIP:   ctx.ClientIP(),
+		UserAgent:  ctx.Request.UserAgent(),
+		Outcome:    outcome,
+	})
+}
+
+// recordFailure 记录一次取件失败，触发锁定时输出日志
+func (r *ReceiveController) recordFailure(ctx *gin.Context, pickupCode string) {
+	if _, err := r.GuardService.RecordFailure(ctx.ClientIP(), pickupCode); err != nil {
+		logger.Error("记录取件失败次数失败", "err", err, "client_ip", ctx.ClientIP())
+	}
+}
 
-	ctx.JSON(200, gin.H{"msg": "文件状态已更新"})
+// tooManyRequests 返回429及Retry-After（秒，向上取整）
+func tooManyRequests(ctx *gin.Context, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	ctx.Header("Retry-After", strconv.Itoa(seconds))
+	ctx.JSON(http.StatusTooManyRequests, gin.H{"error": "尝试次数过多，请稍后再试", "code": "TOO_MANY_ATTEMPTS", "retryAfter": seconds})
 }
 
-// QueryReceiveRecords 查询今天接收的记录
+// QueryReceiveRecords 分页查询调用方接收的记录（默认查询当天）
 func (r *ReceiveController) QueryReceiveRecords(ctx *gin.Context) {
-	// 调用服务层方法查询今天接收的记录
-	records, err := r.ReceiveService.QueryReceiveRecords()
+	query, ok := parseRecordQuery(ctx)
+	if !ok {
+		return
+	}
+
+	// 调用服务层方法查询接收记录
+	page, err := r.ReceiveService.QueryReceiveRecords(currentCaller(ctx), query)
 	if err != nil {
+		if errors.Is(err, service.ErrInvalidCursor) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "分页游标cursor无效"})
+			return
+		}
+		logger.Error("查询接收记录失败", "err", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "查询接收记录失败"})
 		return
 	}
 	// 返回查询结果
-	ctx.JSON(http.StatusOK, gin.H{"records": records})
+	ctx.JSON(http.StatusOK, page)
 }
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"daoke.com/file_trans/conf"
+	"daoke.com/file_trans/repository"
+	"daoke.com/file_trans/service"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// recordDateLayout 记录查询的日期格式，按应用时区解析
+const recordDateLayout = "2006-01-02"
+
+// parseRecordQuery 解析记录查询参数，失败时直接写入错误响应：
+// from/to 为日期（含首尾两天），均未传时默认查询今天；type 为 text/file/e2e；keyword 匹配文件名（不匹配端到端加密分享）；
+// status 为 active/expired/revoked；sortBy 为 time/size；order 为 asc/desc；limit 为每页条数；cursor 为上一页返回的游标
+func parseRecordQuery(ctx *gin.Context) (*service.RecordQuery, bool) {
+	query := &service.RecordQuery{
+		FileType: ctx.Query("type"),
+		Keyword:  ctx.Query("keyword"),
+		Status:   ctx.Query("status"),
+		SortBy:   ctx.Query("sortBy"),
+		Cursor:   ctx.Query("cursor"),
+	}
+
+	from, to := ctx.Query("from"), ctx.Query("to")
+	if from == "" && to == "" {
+		now := time.Now().In(conf.Location)
+		query.From = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, conf.Location)
+	}
+	if from != "" {
+		t, err := time.ParseInLocation(recordDateLayout, from, conf.Location)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "起始日期from格式应为YYYY-MM-DD"})
+			return nil, false
+		}
+		query.From = t
+	}
+	if to != "" {
+		t, err := time.ParseInLocation(recordDateLayout, to, conf.Location)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "截止日期to格式应为YYYY-MM-DD"})
+			return nil, false
+		}
+		// 截止日期当天也包含在内
+		query.To = t.AddDate(0, 0, 1)
+	}
+	if !query.From.IsZero() && !query.To.IsZero() && !query.From.Before(query.To) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "起始日期不能晚于截止日期"})
+		return nil, false
+	}
+
+	switch query.FileType {
+	case "", "text", "file", "e2e":
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "类型type无效"})
+		return nil, false
+	}
+	switch query.Status {
+	case "", repository.RecordStatusActive, repository.RecordStatusExpired, repository.RecordStatusRevoked:
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "状态status无效"})
+		return nil, false
+	}
+	switch query.SortBy {
+	case "", repository.RecordSortTime, repository.RecordSortSize:
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "排序字段sortBy无效"})
+		return nil, false
+	}
+	switch ctx.DefaultQuery("order", "desc") {
+	case "asc":
+		query.Asc = true
+	case "desc":
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "排序方向order无效"})
+		return nil, false
+	}
+
+	if limit := ctx.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "每页条数limit必须为正整数"})
+			return nil, false
+		}
+		query.Limit = n
+	}
+	return query, true
+}
@@ -1,14 +1,20 @@
 package controller
 
 import (
+	"crypto/sha256"
+	"daoke.com/file_trans/conf"
 	"daoke.com/file_trans/logger"
 	"daoke.com/file_trans/model"
 	"daoke.com/file_trans/service"
 	"daoke.com/file_trans/utils"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"io"
+	"mime/multipart"
 	"net/http"
+	"path"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -27,55 +33,68 @@ func NewSendController() *SendController {
 
 // Send 处理发送文件的请求
 func (s *SendController) Send(ctx *gin.Context) {
+	// 先解析表单，请求体超过上限时在此返回（表单发送的文本也可能不是multipart格式）
+	if _, err := ctx.MultipartForm(); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		rejectUpload(ctx, err)
+		return
+	}
+
 	// 获取请求类型：文本或文件
 	transType := ctx.PostForm("type")
 
-	// 获取前端传入的过期时间参数
-	expireTipStr := ctx.PostForm("expireTip")
-	expireUnit := ctx.PostForm("expireUnit")
-
-	// 验证参数存在性
-	if expireTipStr == "" {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请传入过期时间数值expireTip"})
-		return
-	}
-	if expireUnit == "" {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请传入过期时间单位expireUnit"})
+	// 解析过期时间、下载次数等分享选项
+	opts, ok := parseShareOptions(ctx)
+	if !ok {
 		return
 	}
 
-	// 验证数值有效性
-	expireTip, err := strconv.Atoi(expireTipStr)
-	if err != nil || expireTip <= 0 {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "过期时间数值必须为正整数"})
-		return
-	}
+	var (
+		fileURL    string
+		fileName   string
+		fileSize   int64
+		mimeType   string
+		checksum   string
+		shareFiles []model.ShareFile // 多文件分享的文件清单
+		meta       string            // 端到端加密分享的加密元数据
+		inlineText string            // 内联保存的文本（启用存储加密时为密文）
+		language   string            // 文本的语言提示
+		uploaded   *service.UploadResult
+		err        error
+	)
 
-	// 验证单位有效性并计算过期时间
-	var expiry time.Duration
-	var expiresIn int
-	switch expireUnit {
-	case "分钟":
-		expiry = time.Duration(expireTip) * time.Minute
-		expiresIn = expireTip * 60
-	case "小时":
-		expiry = time.Duration(expireTip) * time.Hour
-		expiresIn = expireTip * 3600
-	case "天":
-		expiry = time.Duration(expireTip) * 24 * time.Hour
-		expiresIn = expireTip * 86400
-	default:
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "过期时间单位无效"})
+	// 生成唯一标识，并由服务端分配取件码（发送者可选指定自定义取件码）
+	fileUUID := s.SendService.GenerateFileUUID()
+	pickupCode, ok := allocatePickupCode(ctx, s.SendService, fileUUID, opts)
+	if !ok {
 		return
 	}
+	// 后续任一步骤失败时释放取件码及已上传的文件
+	succeeded := false
+	defer func() {
+		if succeeded {
+			return
+		}
+		s.SendService.ReleasePickupCode(pickupCode, fileUUID)
+		if fileURL != "" {
+			s.SendService.Discard(fileURL)
+		}
+		for _, shareFile := range shareFiles {
+			s.SendService.Discard(shareFile.StorageUrl)
+		}
+	}()
 
+	// 启用存储加密时生成数据密钥，以取件码和服务端密钥派生的密钥包装后随分享记录保存（端到端加密的密文无需再加密）
 	var (
-		fileURL         string
-		fileDownloadURL string
-		fileName        string
-		fileUUID        string
-		fileSize        int64
+		dataKey    []byte
+		wrappedKey string
 	)
+	if service.EncryptionEnabled() && transType != "e2e" {
+		if dataKey, wrappedKey, err = service.NewShareKey(fileUUID, pickupCode); err != nil {
+			logger.Error("生成数据密钥失败", "err", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "生成数据密钥失败"})
+			return
+		}
+	}
 
 	// 根据类型处理
 	if transType == "text" {
@@ -87,9 +106,13 @@ func (s *SendController) Send(ctx *gin.Context) {
 		}
 
 		// 验证文本内容长度
-		maxTextSize := 10 * 1024 * 1024 // 10MB
-		if len(textContent) > maxTextSize {
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": "文本内容过大，最大支持10MB"})
+		if err := service.CheckTextSize(int64(len(textContent))); err != nil {
+			rejectUpload(ctx, err)
+			return
+		}
+
+		// 语言提示（可选），取件方据此进行语法高亮
+		if language, ok = parseLanguage(ctx); !ok {
 			return
 		}
 
@@ -97,15 +120,30 @@ func (s *SendController) Send(ctx *gin.Context) {
 		fileName = fmt.Sprintf("文本_%d.txt", time.Now().UnixNano())
 		fileSize = int64(len(textContent))
 
-		// 将文本内容转换为Reader
-		reader := strings.NewReader(textContent)
+		if fileSize <= service.InlineTextMaxSize() {
+			// 小文本直接保存在数据库中，取件时随响应返回，不写入存储
+			inlineText = textContent
+			if dataKey != nil {
+				if inlineText, err = service.SealInlineText(dataKey, fileUUID, textContent); err != nil {
+					logger.Error("加密文本失败", "err", err)
+					ctx.JSON(http.StatusInternalServerError, gin.H{"error": "处理文本失败"})
+					return
+				}
+			}
+			sum := sha256.Sum256([]byte(textContent))
+			mimeType, checksum = service.TextContentType, hex.EncodeToString(sum[:])
+		} else {
+			// 将文本内容转换为Reader
+			reader := strings.NewReader(textContent)
 
-		// 调用统一上传方法，指定类型为"text"
-		fileURL, err = s.SendService.UploadToMinIO(fileName, fileSize, reader, "text")
-		if err != nil {
-			logger.Error("上传文本文件失败", "err", err)
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "处理文本失败"})
-			return
+			// 调用统一上传方法，指定类型为"text"
+			uploaded, err = s.SendService.UploadToStorage(fileName, fileSize, reader, "text", dataKey)
+			if err != nil {
+				logger.Error("上传文本文件失败", "err", err)
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "处理文本失败"})
+				return
+			}
+			fileURL, mimeType, checksum = uploaded.URL, uploaded.MimeType, uploaded.Sha256
 		}
 
 	} else if transType == "file" {
@@ -122,42 +160,35 @@ func (s *SendController) Send(ctx *gin.Context) {
 			ctx.JSON(http.StatusBadRequest, gin.H{"error": "未找到文件"})
 			return
 		}
-
-		// 判断文件数量，超过2个则压缩
-		if len(files) >= 2 {
-			fileMap := make(map[string]io.Reader)
-			fileSizeTotal := int64(0)
-
-			// 收集所有文件
-			for _, file := range files {
-				src, err := file.Open()
-				if err != nil {
-					ctx.JSON(http.StatusInternalServerError, gin.H{"error": "打开文件失败"})
-					return
-				}
-				defer src.Close()
-
-				fileMap[file.Filename] = src
-				fileSizeTotal += file.Size
+		if err := service.CheckFileCount(len(files)); err != nil {
+			rejectUpload(ctx, err)
+			return
+		}
+		// 内容类型以上传时根据文件头识别的结果为准，不信任客户端声明的 Content-Type
+		for _, file := range files {
+			if err := service.CheckUploadFile(file.Filename, file.Size, ""); err != nil {
+				rejectUpload(ctx, err)
+				return
 			}
+		}
 
-			// 压缩文件
-			zipReader, zipSize, err := utils.CompressFiles(fileMap)
-			if err != nil {
-				logger.Error("压缩文件失败", "err", err)
-				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "压缩文件失败"})
+		// 多个文件逐个单独存储，取件时可按需下载单个文件或打包下载全部文件
+		if len(files) >= 2 {
+			// 上传文件夹时 paths 按顺序携带每个文件的相对路径
+			paths := form.Value["paths"]
+			if len(paths) != 0 && len(paths) != len(files) {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "paths 数量需与文件数量一致"})
 				return
 			}
-
-			fileName = fmt.Sprintf("files_%d.zip", time.Now().UnixNano())
-			fileSize = zipSize
-
-			// 调用统一上传方法，指定类型为"file"
-			fileURL, err = s.SendService.UploadToMinIO(fileName, fileSize, zipReader, "file")
-			if err != nil {
-				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "文件上传失败"})
+			shareFiles, ok = s.uploadBundle(ctx, fileUUID, files, paths, dataKey)
+			if !ok {
 				return
 			}
+			for _, shareFile := range shareFiles {
+				fileSize += shareFile.FileSize
+			}
+			fileName = bundleName(shareFiles)
+			mimeType = "application/zip"
 		} else {
 			// 单个文件直接上传
 			file := files[0]
@@ -172,79 +203,409 @@ func (s *SendController) Send(ctx *gin.Context) {
 			fileSize = file.Size
 
 			// 调用统一上传方法，指定类型为"file"
-			fileURL, err = s.SendService.UploadToMinIO(fileName, fileSize, src, "file")
+			uploaded, err = s.SendService.UploadToStorage(fileName, fileSize, src, "file", dataKey)
 			if err != nil {
-				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "文件上传失败"})
+				uploadFailed(ctx, err)
 				return
 			}
+			fileURL, mimeType, checksum = uploaded.URL, uploaded.MimeType, uploaded.Sha256
+		}
+	} else if transType == "e2e" {
+		// 端到端加密：客户端加密后上传单个密文文件，密钥不经过服务端（如放在链接的 # 片段中），
+		// 文件名、大小等元数据同样由客户端加密后放在 encryptedMeta 中，服务端原样保存，不识别类型、不打包
+		meta = ctx.PostForm("encryptedMeta")
+		if err := service.CheckEncryptedMeta(meta); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("加密元数据encryptedMeta需为base64编码，且不超过%d个字符", service.MaxEncryptedMetaSize)})
+			return
+		}
+
+		form, err := ctx.MultipartForm()
+		if err != nil {
+			logger.Warn("获取上传文件失败", "err", err, "client_ip", ctx.ClientIP())
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "获取文件失败"})
+			return
+		}
+		if len(form.File["files"]) != 1 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "端到端加密分享需上传一个密文文件，多个文件请在客户端打包后加密"})
+			return
+		}
+		file := form.File["files"][0]
+		if err := service.CheckEncryptedUpload(file.Size); err != nil {
+			rejectUpload(ctx, err)
+			return
+		}
+		src, err := file.Open()
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "打开文件失败"})
+			return
+		}
+		defer src.Close()
+
+		// 真实文件名只存在于加密元数据中，使用占位名称
+		fileName = fmt.Sprintf("encrypted_%d.bin", time.Now().UnixNano())
+		fileSize = file.Size
+		uploaded, err = s.SendService.UploadToStorage(fileName, fileSize, src, "e2e", nil)
+		if err != nil {
+			uploadFailed(ctx, err)
+			return
 		}
+		fileURL, mimeType, checksum = uploaded.URL, uploaded.MimeType, uploaded.Sha256
 	} else {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的传输类型"})
 		return
 	}
 
-	// 为所有类型生成预签名下载链接
-	bucketName, objectName := utils.ParseStorageURL(fileURL)
-	fileDownloadURL, err = utils.GeneratePreSignedDownloadURL(bucketName, objectName, 15*time.Minute)
-	if err != nil {
-		logger.Error("生成文件下载链接失败", "err", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "生成文件下载链接失败!"})
+	// 保存文件信息到数据库
+	transInfo := &model.TransInfo{
+		FileUuid:      fileUUID,
+		FileName:      fileName,
+		FileSize:      fileSize,
+		MimeType:      mimeType,
+		Category:      utils.FileCategory(mimeType),
+		Sha256:        checksum,
+		StorageUrl:    fileURL,
+		FileType:      transType, // 保存类型标识
+		WrappedKey:    wrappedKey,
+		Files:         shareFiles,
+		EncryptedMeta: meta,
+		TextContent:   inlineText,
+		Language:      language,
+		DataKey:       dataKey,
+	}
+	succeeded = finishShare(ctx, s.SendService, transInfo, pickupCode, opts)
+}
+
+// LimitRequestBody 中间件：限制表单发送的请求体大小，声明的长度超限时直接拒绝，
+// 否则在读取请求体超过上限时中断解析
+func (s *SendController) LimitRequestBody(ctx *gin.Context) {
+	limit := service.MaxRequestSize()
+	if ctx.Request.ContentLength > limit {
+		rejectUpload(ctx, &http.MaxBytesError{Limit: limit})
+		ctx.Abort()
+		return
+	}
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, limit)
+	ctx.Next()
+}
+
+// rejectUpload 上传内容超出限制时写入错误响应，code 供前端区分具体原因，limit 为对应的上限
+func rejectUpload(ctx *gin.Context, err error) {
+	uploadConf := conf.AppConfig.Upload
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("请求内容过大，最大支持%dMB，大文件请使用分片上传", uploadConf.MaxRequestSizeMB),
+			"code": "REQUEST_TOO_LARGE", "limit": maxBytesErr.Limit})
+	case errors.Is(err, service.ErrFileTooLarge):
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("文件过大，单个文件最大支持%dMB", uploadConf.MaxFileSizeMB),
+			"code": "FILE_TOO_LARGE", "limit": service.MaxFileSize()})
+	case errors.Is(err, service.ErrTextTooLarge):
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("文本内容过大，最大支持%dMB", uploadConf.MaxTextSizeMB),
+			"code": "TEXT_TOO_LARGE", "limit": service.MaxTextSize()})
+	case errors.Is(err, service.ErrTooManyFiles):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("文件数量过多，单次最多发送%d个文件", uploadConf.MaxFiles),
+			"code": "TOO_MANY_FILES", "limit": uploadConf.MaxFiles})
+	case errors.Is(err, service.ErrFileTypeNotAllowed):
+		ctx.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "不支持上传该类型的文件",
+			"code": "FILE_TYPE_NOT_ALLOWED", "allowedExtensions": uploadConf.AllowedExtensions, "allowedMimeTypes": uploadConf.AllowedMimeTypes})
+	default:
+		logger.Warn("解析上传表单失败", "err", err, "client_ip", ctx.ClientIP())
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "解析上传内容失败"})
+	}
+}
+
+// languagePattern 语言提示的格式（小写，如 go、c++、objective-c、f#）
+var languagePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9+#._-]{0,31}$`)
+
+// parseLanguage 解析表单中可选的语言提示，格式不正确时直接写入错误响应
+func parseLanguage(ctx *gin.Context) (string, bool) {
+	language := strings.ToLower(strings.TrimSpace(ctx.PostForm("language")))
+	if language != "" && !languagePattern.MatchString(language) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "语言提示language格式不正确"})
+		return "", false
+	}
+	return language, true
+}
+
+// uploadFailed 写入文件上传失败的响应（文件类型不在允许范围内时返回415）
+func uploadFailed(ctx *gin.Context, err error) {
+	if errors.Is(err, service.ErrFileTypeNotAllowed) {
+		rejectUpload(ctx, err)
 		return
 	}
+	ctx.JSON(http.StatusInternalServerError, gin.H{"error": "文件上传失败"})
+}
+
+// uploadBundle 将多个文件逐个上传到存储（dataKey 不为空时加密存储），返回文件清单（相对路径经过清理，重名时自动追加序号）；
+// 任一文件失败时删除已上传的文件并直接写入错误响应
+func (s *SendController) uploadBundle(ctx *gin.Context, fileUUID string, files []*multipart.FileHeader, paths []string, dataKey []byte) ([]model.ShareFile, bool) {
+	shareFiles := make([]model.ShareFile, 0, len(files))
+	namer := utils.NewEntryNamer()
+	succeeded := false
+	defer func() {
+		if succeeded {
+			return
+		}
+		for _, shareFile := range shareFiles {
+			s.SendService.Discard(shareFile.StorageUrl)
+		}
+	}()
 
-	// 生成唯一标识
-	fileUUID = s.SendService.GenerateFileUUID()
-	accessKey := ctx.PostForm("pickupCode")
+	for seq, file := range files {
+		entryPath := ""
+		if len(paths) != 0 {
+			entryPath = utils.SanitizeEntryPath(paths[seq])
+		}
+		if entryPath == "" {
+			entryPath = utils.SanitizeEntryPath(file.Filename)
+		}
+		if entryPath == "" {
+			entryPath = "file"
+		}
+		entryPath = namer.Unique(entryPath)
+		fileName := path.Base(entryPath)
 
-	// 保存文件信息到数据库
-	transInfo := &model.TransInfo{
-		FileUuid:   fileUUID,
-		FileName:   fileName,
-		FileSize:   fileSize,
-		StorageUrl: fileURL,
-		FileType:   transType, // 保存类型标识
+		src, err := file.Open()
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "打开文件失败"})
+			return nil, false
+		}
+		uploaded, err := s.SendService.UploadToStorage(fileName, file.Size, src, "file", dataKey)
+		src.Close()
+		if err != nil {
+			uploadFailed(ctx, err)
+			return nil, false
+		}
+
+		shareFiles = append(shareFiles, model.ShareFile{
+			FileUuid:   fileUUID,
+			Seq:        seq,
+			FileName:   fileName,
+			Path:       entryPath,
+			FileSize:   file.Size,
+			MimeType:   uploaded.MimeType,
+			Category:   utils.FileCategory(uploaded.MimeType),
+			Sha256:     uploaded.Sha256,
+			StorageUrl: uploaded.URL,
+		})
 	}
+	succeeded = true
+	return shareFiles, true
+}
 
-	if err := s.SendService.SaveToDB(transInfo); err != nil {
-		logger.Error("保存文件信息到数据库失败", "err", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件信息失败"})
+// bundleName 打包下载全部文件时的压缩包名称：上传的是单个文件夹时使用文件夹名称
+func bundleName(shareFiles []model.ShareFile) string {
+	folder, _, found := strings.Cut(shareFiles[0].Path, "/")
+	for _, shareFile := range shareFiles {
+		if !found || !strings.HasPrefix(shareFile.Path, folder+"/") {
+			return fmt.Sprintf("files_%d.zip", time.Now().UnixNano())
+		}
+	}
+	return folder + ".zip"
+}
+
+// shareOptions 发送时由发送者指定的分享选项
+type shareOptions struct {
+	expiry       time.Duration // 过期时长
+	expiresIn    int           // 过期秒数（返回给前端）
+	expireUnit   string        // 过期时间单位
+	maxDownloads int           // 最大下载次数，0表示不限，1即阅后即焚
+	passwordHash string        // 分享密码哈希，为空表示无密码
+}
+
+// apply 将分享选项写入分享记录
+func (o *shareOptions) apply(transInfo *model.TransInfo) {
+	transInfo.ExpireAt = time.Now().Add(o.expiry)
+	transInfo.MaxDownloads = o.maxDownloads
+	transInfo.PasswordHash = o.passwordHash
+}
+
+// parseShareOptions 解析并校验表单中的分享选项，校验失败时直接写入错误响应
+func parseShareOptions(ctx *gin.Context) (*shareOptions, bool) {
+	expiry, expiresIn, expireUnit, ok := parseExpiry(ctx)
+	if !ok {
+		return nil, false
+	}
+	opts := &shareOptions{expiry: expiry, expiresIn: expiresIn, expireUnit: expireUnit}
+
+	// 最大下载次数（可选）
+	if maxDownloadsStr := ctx.PostForm("maxDownloads"); maxDownloadsStr != "" {
+		maxDownloads, err := strconv.Atoi(maxDownloadsStr)
+		if err != nil || maxDownloads < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "最大下载次数必须为非负整数"})
+			return nil, false
+		}
+		opts.maxDownloads = maxDownloads
+	}
+
+	// 分享密码（可选），只保存哈希
+	if password := ctx.PostForm("password"); password != "" {
+		if len(password) < 4 || len(password) > 72 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "分享密码长度需为4-72个字符"})
+			return nil, false
+		}
+		hash, err := utils.HashPassword(password)
+		if err != nil {
+			logger.Error("生成密码哈希失败", "err", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "设置分享密码失败"})
+			return nil, false
+		}
+		opts.passwordHash = hash
+	}
+	return opts, true
+}
+
+// parseExpiry 解析并校验表单中的过期时间参数（expireTip/expireUnit），校验失败时直接写入错误响应
+func parseExpiry(ctx *gin.Context) (expiry time.Duration, expiresIn int, expireUnit string, ok bool) {
+	// 获取前端传入的过期时间参数
+	expireTipStr := ctx.PostForm("expireTip")
+	expireUnit = ctx.PostForm("expireUnit")
+
+	// 验证参数存在性
+	if expireTipStr == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请传入过期时间数值expireTip"})
+		return
+	}
+	if expireUnit == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请传入过期时间单位expireUnit"})
 		return
 	}
 
-	// 保存到Redis
-	if err := s.SendService.SaveToRedis(accessKey, fileUUID, expiry); err != nil {
-		logger.Error("保存文件信息到Redis失败", "err", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "保存访问密钥失败"})
+	// 验证数值有效性
+	expireTip, err := strconv.Atoi(expireTipStr)
+	if err != nil || expireTip <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "过期时间数值必须为正整数"})
 		return
 	}
 
-	// 更新文件发送状态
-	if err := s.SendService.UpdateSendStatus(fileUUID); err != nil {
-		logger.Error("更新文件发送状态失败", "err", err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "更新文件发送状态失败"})
+	// 验证单位有效性
+	var unit time.Duration
+	switch expireUnit {
+	case "分钟":
+		unit = time.Minute
+	case "小时":
+		unit = time.Hour
+	case "天":
+		unit = 24 * time.Hour
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "过期时间单位无效"})
 		return
 	}
 
+	// 先按单位与上限比较再计算过期时间，避免数值过大时溢出
+	maxExpiry := conf.AppConfig.Upload.MaxExpiry
+	if int64(expireTip) > int64(maxExpiry/unit) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "过期时间超出上限",
+			"code": "EXPIRY_TOO_LONG", "limit": int64(maxExpiry / time.Second)})
+		return
+	}
+	expiry = time.Duration(expireTip) * unit
+	return expiry, int(expiry / time.Second), expireUnit, true
+}
+
+// allocatePickupCode 写入下载次数限制并分配取件码（表单中的pickupCode为可选的自定义取件码），失败时直接写入错误响应
+func allocatePickupCode(ctx *gin.Context, sendService *service.SendService, fileUUID string, opts *shareOptions) (string, bool) {
+	// 下载次数限制需在取件码生效前写入
+	if err := sendService.SetDownloadLimit(fileUUID, opts.maxDownloads, opts.expiry); err != nil {
+		logger.Error("保存下载次数限制失败", "err", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "分配取件码失败"})
+		return "", false
+	}
+
+	pickupCode, err := sendService.AllocatePickupCode(ctx.PostForm("pickupCode"), fileUUID, opts.expiry)
+	if err != nil {
+		sendService.ReleasePickupCode("", fileUUID)
+		switch {
+		case errors.Is(err, service.ErrPickupCodeInvalid):
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "自定义取件码格式不正确"})
+		case errors.Is(err, service.ErrPickupCodeConflict):
+			ctx.JSON(http.StatusConflict, gin.H{"error": "该取件码已被占用，请更换"})
+		default:
+			logger.Error("分配取件码失败", "err", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "分配取件码失败"})
+		}
+		return "", false
+	}
+	return pickupCode, true
+}
+
+// finishShare 文件上传完成后生成下载链接、保存分享记录并返回发送结果，失败时直接写入错误响应
+func finishShare(ctx *gin.Context, sendService *service.SendService, transInfo *model.TransInfo,
+	pickupCode string, opts *shareOptions) bool {
+	opts.apply(transInfo)
+	transInfo.FileCount = max(len(transInfo.Files), 1)
+	caller := currentCaller(ctx)
+	transInfo.UserID = caller.UserID
+	transInfo.SenderDevice = caller.DeviceHash
+
+	// 生成管理令牌，数据库中只保存哈希，明文仅在本次响应中返回给发送者
+	manageToken, err := utils.GenerateToken()
+	if err != nil {
+		logger.Error("生成管理令牌失败", "err", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "生成管理令牌失败"})
+		return false
+	}
+	transInfo.ManageToken = utils.HashToken(manageToken)
+
+	// 为所有类型生成下载链接
+	fileDownloadURL, err := service.DownloadURL(ctx, transInfo)
+	if err != nil {
+		logger.Error("生成文件下载链接失败", "err", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "生成文件下载链接失败!"})
+		return false
+	}
+
+	// 保存分享记录（连同文件清单在同一事务中写入）是最后一个可能失败的步骤：
+	// 保存失败时数据库中没有记录，由调用方释放已上传的文件；保存成功后存储对象只由过期清理或撤销释放
+	transInfo.SendStatus = true
+	if err := sendService.SaveToDB(transInfo); err != nil {
+		logger.Error("保存文件信息到数据库失败", "err", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件信息失败"})
+		return false
+	}
+
 	ctx.JSON(http.StatusOK, gin.H{
 		"msg":             "发送成功",
-		"fileName":        fileName,
-		"fileSize":        fileSize,
+		"fileName":        transInfo.FileName,
+		"fileSize":        transInfo.FileSize,
+		"fileCount":       transInfo.FileCount,
+		"mimeType":        transInfo.MimeType,
+		"category":        transInfo.Category,
+		"sha256":          transInfo.Sha256,               // 多文件分享为空，各文件的校验值见 files
+		"files":           service.NewFileList(transInfo), // 文件清单（不含下载链接）
 		"fileDownloadURL": fileDownloadURL,
-		"fileUuid":        fileUUID,
-		"accessKey":       accessKey,
-		"expiresIn":       expiresIn,
-		"unit":            expireUnit,
-		"type":            transType, // 返回类型，前端可能需要
+		"fileUuid":        transInfo.FileUuid,
+		"pickupCode":      pickupCode,
+		"accessKey":       pickupCode, // 兼容旧版前端
+		"expiresIn":       opts.expiresIn,
+		"unit":            opts.expireUnit,
+		"maxDownloads":    transInfo.MaxDownloads,
+		"hasPassword":     transInfo.PasswordHash != "",
+		"encrypted":       transInfo.IsEncrypted(), // 加密存储，只能通过代理下载
+		"inline":          transInfo.IsInline(),    // 文本内联保存，取件时随响应返回，没有下载链接
+		"language":        transInfo.Language,
+		"manageToken":     manageToken,        // 凭此令牌撤销、延期及查看下载统计，仅返回一次
+		"type":            transInfo.FileType, // 返回类型，前端可能需要
 	})
+	return true
 }
 
-// QuerySendRecords 查询发送记录
+// QuerySendRecords 分页查询调用方的发送记录（默认查询当天）
 func (s *SendController) QuerySendRecords(context *gin.Context) {
-	//查询当天的发送记录
-	records, err := s.SendService.QuerySendRecords()
+	query, ok := parseRecordQuery(context)
+	if !ok {
+		return
+	}
+
+	page, err := s.SendService.QuerySendRecords(currentCaller(context), query)
 	if err != nil {
+		if errors.Is(err, service.ErrInvalidCursor) {
+			context.JSON(http.StatusBadRequest, gin.H{"error": "分页游标cursor无效"})
+			return
+		}
+		logger.Error("查询发送记录失败", "err", err)
 		context.JSON(http.StatusInternalServerError, gin.H{"error": "查询发送记录失败"})
 		return
 	}
-	context.JSON(http.StatusOK, gin.H{"records": records})
+	context.JSON(http.StatusOK, page)
 }
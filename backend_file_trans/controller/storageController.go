@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"daoke.com/file_trans/logger"
+	"daoke.com/file_trans/service"
+	"daoke.com/file_trans/storage"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"path"
+)
+
+type StorageController struct {
+	LocalStore      *storage.LocalStore // 非本地存储后端时为nil
+	DownloadService *service.DownloadService
+}
+
+// NewStorageController 创建一个新的 StorageController 实例
+func NewStorageController() *StorageController {
+	localStore, _ := storage.Default.(*storage.LocalStore)
+	return &StorageController{
+		LocalStore:      localStore,
+		DownloadService: service.NewDownloadService(),
+	}
+}
+
+// Download 校验签名后提供本地存储对象的下载，支持Range请求
+func (s *StorageController) Download(ctx *gin.Context) {
+	objectName, fileName := ctx.Query("object"), ctx.Query("name")
+	trackingID := ctx.Query("track")
+	if err := s.LocalStore.Verify(objectName, fileName, ctx.Query("expires"), trackingID, ctx.Query("sig")); err != nil {
+		if errors.Is(err, storage.ErrLinkExpired) {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "下载链接已过期"})
+			return
+		}
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "下载链接无效"})
+		return
+	}
+
+	object, info, err := s.LocalStore.Get(ctx, objectName)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
+			return
+		}
+		logger.Error("读取本地存储对象失败", "err", err, "object", objectName)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "读取文件失败"})
+		return
+	}
+	defer object.Close()
+
+	if fileName == "" {
+		fileName = path.Base(objectName)
+	}
+	served := serveObject(ctx, object, info, fileName)
+	s.DownloadService.RecordServed(ctx, trackingID, info.Size, served, ctx.ClientIP(), ctx.Request.UserAgent())
+}
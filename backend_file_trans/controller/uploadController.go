@@ -0,0 +1,337 @@
+package controller
+
+import (
+	"daoke.com/file_trans/logger"
+	"daoke.com/file_trans/model"
+	"daoke.com/file_trans/service"
+	"daoke.com/file_trans/utils"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+type UploadController struct {
+	UploadService *service.UploadService
+	SendService   *service.SendService
+}
+
+// NewUploadController 创建一个新的 UploadController 实例
+func NewUploadController() *UploadController {
+	return &UploadController{
+		UploadService: service.NewUploadService(),
+		SendService:   service.NewSendService(),
+	}
+}
+
+// RequireStorageSupport 中间件：存储后端不支持分片上传与预签名直传时返回501；
+// 启用存储加密时同样返回501，这两种方式的文件内容不经过后端，无法加密存储
+func (u *UploadController) RequireStorageSupport(ctx *gin.Context) {
+	if !u.UploadService.Supported() {
+		ctx.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{"error": "当前存储后端不支持该上传方式"})
+		return
+	}
+	if service.EncryptionEnabled() {
+		ctx.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{"error": "已启用存储加密，请通过表单发送文件",
+			"code": "ENCRYPTION_UNSUPPORTED"})
+		return
+	}
+	ctx.Next()
+}
+
+// InitUpload 初始化分片上传，返回会话标识和分片大小
+func (u *UploadController) InitUpload(ctx *gin.Context) {
+	fileName := ctx.PostForm("fileName")
+	fileSize, err := strconv.ParseInt(ctx.PostForm("fileSize"), 10, 64)
+	if fileName == "" || err != nil || fileSize <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请传入正确的文件名fileName和文件大小fileSize"})
+		return
+	}
+
+	if err := service.CheckUploadFile(fileName, fileSize, ""); err != nil {
+		rejectUpload(ctx, err)
+		return
+	}
+
+	session, err := u.UploadService.InitUpload(fileName, fileSize)
+	if err != nil {
+		if errors.Is(err, service.ErrUploadTooManyParts) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "文件过大，超出分片上传上限"})
+			return
+		}
+		logger.Error("初始化分片上传失败", "err", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "初始化分片上传失败"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"uploadId":   session.UploadID,
+		"partSize":   session.PartSize,
+		"totalParts": session.TotalParts,
+	})
+}
+
+// UploadPart 上传单个分片，请求体为分片的原始字节
+func (u *UploadController) UploadPart(ctx *gin.Context) {
+	session, ok := u.getSession(ctx)
+	if !ok {
+		return
+	}
+
+	partNumber, err := strconv.Atoi(ctx.Param("partNumber"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "分片序号无效"})
+		return
+	}
+
+	part, err := u.UploadService.UploadPart(session, partNumber, ctx.Request.Body, ctx.Request.ContentLength)
+	if err != nil {
+		if errors.Is(err, service.ErrUploadInvalidPart) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "分片序号或大小不正确"})
+			return
+		}
+		logger.Error("上传分片失败", "err", err, "uploadId", session.UploadID, "part", partNumber)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "上传分片失败"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"part": part})
+}
+
+// GetUploadStatus 查询已上传的分片，客户端据此跳过已完成的分片实现断点续传
+func (u *UploadController) GetUploadStatus(ctx *gin.Context) {
+	session, ok := u.getSession(ctx)
+	if !ok {
+		return
+	}
+
+	parts, err := u.UploadService.ListParts(session)
+	if err != nil {
+		logger.Error("查询已上传分片失败", "err", err, "uploadId", session.UploadID)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "查询已上传分片失败"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"uploadId":   session.UploadID,
+		"fileName":   session.FileName,
+		"fileSize":   session.FileSize,
+		"partSize":   session.PartSize,
+		"totalParts": session.TotalParts,
+		"parts":      parts,
+	})
+}
+
+// CompleteUpload 合并分片，并像 Send 一样创建分享记录和取件码
+func (u *UploadController) CompleteUpload(ctx *gin.Context) {
+	session, ok := u.getSession(ctx)
+	if !ok {
+		return
+	}
+
+	// 解析过期时间、下载次数等分享选项
+	opts, ok := parseShareOptions(ctx)
+	if !ok {
+		return
+	}
+
+	// 先分配取件码，自定义取件码冲突时客户端可更换后重试，分片不受影响
+	fileUUID := u.SendService.GenerateFileUUID()
+	pickupCode, ok := allocatePickupCode(ctx, u.SendService, fileUUID, opts)
+	if !ok {
+		return
+	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			u.SendService.ReleasePickupCode(pickupCode, fileUUID)
+		}
+	}()
+
+	fileURL, err := u.UploadService.CompleteUpload(session)
+	if err != nil {
+		if errors.Is(err, service.ErrUploadIncomplete) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "仍有分片未上传完成"})
+			return
+		}
+		logger.Error("合并分片失败", "err", err, "uploadId", session.UploadID)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "合并分片失败"})
+		return
+	}
+
+	inspected, ok := u.inspect(ctx, fileURL)
+	if !ok {
+		return
+	}
+	defer func() {
+		if !succeeded {
+			u.SendService.Discard(inspected.URL)
+		}
+	}()
+
+	transInfo := &model.TransInfo{
+		FileUuid:   fileUUID,
+		FileName:   session.FileName,
+		FileSize:   session.FileSize,
+		MimeType:   inspected.MimeType,
+		Category:   utils.FileCategory(inspected.MimeType),
+		StorageUrl: inspected.URL,
+		FileType:   "file",
+	}
+	if succeeded = finishShare(ctx, u.SendService, transInfo, pickupCode, opts); succeeded {
+		u.SendService.HashStoredObject(fileUUID, inspected.URL)
+	}
+}
+
+// AbortUpload 取消分片上传
+func (u *UploadController) AbortUpload(ctx *gin.Context) {
+	session, ok := u.getSession(ctx)
+	if !ok {
+		return
+	}
+
+	if err := u.UploadService.AbortUpload(session); err != nil {
+		logger.Error("取消分片上传失败", "err", err, "uploadId", session.UploadID)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "取消分片上传失败"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"msg": "已取消上传"})
+}
+
+// PresignUpload 生成直传对象存储的预签名上传策略，文件内容不经过后端
+func (u *UploadController) PresignUpload(ctx *gin.Context) {
+	fileName := ctx.PostForm("fileName")
+	fileSize, err := strconv.ParseInt(ctx.PostForm("fileSize"), 10, 64)
+	if fileName == "" || err != nil || fileSize <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请传入正确的文件名fileName和文件大小fileSize"})
+		return
+	}
+
+	if err := service.CheckUploadFile(fileName, fileSize, ctx.PostForm("contentType")); err != nil {
+		rejectUpload(ctx, err)
+		return
+	}
+
+	presigned, err := u.UploadService.PresignUpload(fileName, fileSize, ctx.PostForm("contentType"))
+	if err != nil {
+		if errors.Is(err, service.ErrUploadInvalidParams) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "上传参数无效"})
+			return
+		}
+		logger.Error("生成直传上传凭证失败", "err", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "生成上传凭证失败"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"uploadId":    presigned.UploadID,
+		"url":         presigned.URL,
+		"formData":    presigned.FormData,
+		"contentType": presigned.ContentType,
+		"expiresAt":   presigned.ExpiresAt,
+	})
+}
+
+// ConfirmPresignedUpload 客户端直传完成后确认上传，校验对象后创建分享记录和取件码
+func (u *UploadController) ConfirmPresignedUpload(ctx *gin.Context) {
+	presigned, err := u.UploadService.GetPresignedUpload(ctx.Param("uploadId"))
+	if err != nil {
+		if errors.Is(err, service.ErrUploadNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "上传凭证不存在或已过期"})
+			return
+		}
+		logger.Error("获取直传上传凭证失败", "err", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "获取上传凭证失败"})
+		return
+	}
+
+	// 解析过期时间、下载次数等分享选项
+	opts, ok := parseShareOptions(ctx)
+	if !ok {
+		return
+	}
+
+	fileUUID := u.SendService.GenerateFileUUID()
+	pickupCode, ok := allocatePickupCode(ctx, u.SendService, fileUUID, opts)
+	if !ok {
+		return
+	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			u.SendService.ReleasePickupCode(pickupCode, fileUUID)
+		}
+	}()
+
+	fileURL, err := u.UploadService.ConfirmPresignedUpload(presigned)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUploadIncomplete):
+			ctx.JSON(http.StatusConflict, gin.H{"error": "文件尚未上传完成"})
+		case errors.Is(err, service.ErrUploadMismatch):
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "上传的文件与声明的大小或类型不符"})
+		case errors.Is(err, service.ErrUploadNotFound):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "上传凭证不存在或已确认"})
+		default:
+			logger.Error("确认直传上传失败", "err", err, "uploadId", presigned.UploadID)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "确认上传失败"})
+		}
+		return
+	}
+
+	inspected, ok := u.inspect(ctx, fileURL)
+	if !ok {
+		return
+	}
+	defer func() {
+		if !succeeded {
+			u.SendService.Discard(inspected.URL)
+		}
+	}()
+
+	transInfo := &model.TransInfo{
+		FileUuid:   fileUUID,
+		FileName:   presigned.FileName,
+		FileSize:   presigned.FileSize,
+		MimeType:   inspected.MimeType,
+		Category:   utils.FileCategory(inspected.MimeType),
+		StorageUrl: inspected.URL,
+		FileType:   "file",
+	}
+	if succeeded = finishShare(ctx, u.SendService, transInfo, pickupCode, opts); succeeded {
+		u.SendService.HashStoredObject(fileUUID, inspected.URL)
+	}
+}
+
+// inspect 读取已上传对象的文件头识别内容类型，失败（包括类型不在允许范围内）时删除对象并直接写入错误响应
+func (u *UploadController) inspect(ctx *gin.Context, fileURL string) (*service.UploadResult, bool) {
+	result, err := u.SendService.InspectStoredObject(fileURL)
+	if err != nil {
+		// 对象尚未被任何分享引用，任何失败都需删除，否则成为无人清理的孤儿对象
+		u.SendService.Discard(fileURL)
+		if errors.Is(err, service.ErrFileTypeNotAllowed) {
+			rejectUpload(ctx, err)
+			return nil, false
+		}
+		logger.Error("读取已上传文件失败", "err", err, "storageUrl", fileURL)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "读取已上传文件失败"})
+		return nil, false
+	}
+	return result, true
+}
+
+// getSession 根据路径参数获取分片上传会话，失败时直接写入错误响应
+func (u *UploadController) getSession(ctx *gin.Context) (*service.UploadSession, bool) {
+	session, err := u.UploadService.GetSession(ctx.Param("uploadId"))
+	if err != nil {
+		if errors.Is(err, service.ErrUploadNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "上传会话不存在或已过期"})
+			return nil, false
+		}
+		logger.Error("获取上传会话失败", "err", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "获取上传会话失败"})
+		return nil, false
+	}
+	return session, true
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/redis/go-redis/v9"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"log"
 )
 
 var DBClient *gorm.DB
@@ -19,7 +20,8 @@ func InitDB() {
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
 		mysqlConf.User, mysqlConf.Password, mysqlConf.Host, mysqlConf.Port, mysqlConf.DBName)
 	client, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
-		Logger: logger.Default,
+		Logger:         logger.Default,
+		TranslateError: true, // 将唯一键冲突等数据库错误转换为 gorm.ErrDuplicatedKey 等通用错误
 	})
 
 	if err != nil {
@@ -40,6 +42,14 @@ func InitDB() {
 	logger.Info("连接数据库成功")
 }
 
+// AutoMigrate 根据模型自动同步表结构
+func AutoMigrate(models ...interface{}) {
+	if err := DBClient.AutoMigrate(models...); err != nil {
+		log.Fatalf("同步数据表结构失败：%v", err)
+	}
+	logger.Info("同步数据表结构成功")
+}
+
 // GetDB 获取数据库连接
 func GetDB() *gorm.DB {
 	return DBClient
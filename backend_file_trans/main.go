@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"daoke.com/file_trans/conf"
 	"daoke.com/file_trans/database"
 	"daoke.com/file_trans/logger"
+	"daoke.com/file_trans/model"
 	"daoke.com/file_trans/router"
+	"daoke.com/file_trans/service"
+	"daoke.com/file_trans/storage"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"log"
@@ -25,10 +29,14 @@ func main() {
 	logger.InitLogger()
 	// 初始化数据库
 	database.InitDB()
+	// 同步数据表结构
+	database.AutoMigrate(&model.User{}, &model.TransInfo{}, &model.ShareFile{}, &model.Blob{}, &model.ReapRecord{}, &model.DownloadEvent{})
 	// 初始化Redis
 	database.InitRedis()
-	// 初始化MinIO
-	database.InitMinIO()
+	// 初始化存储后端（MinIO或本地磁盘）
+	storage.InitStorage()
+	// 启动过期清理任务
+	service.NewReaperService().Start(context.Background())
 	// 初始化路由
 	r := router.InitRouter()
 	// 启动服务器
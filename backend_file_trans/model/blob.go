@@ -0,0 +1,20 @@
+package model
+
+import (
+	"time"
+)
+
+// Blob 按内容哈希索引的存储对象，内容相同的文件共用一个存储对象，引用计数归零时删除
+type Blob struct {
+	ID         uint      `gorm:"primaryKey"`
+	Sha256     string    `gorm:"type:char(64);not null;unique"`                     // 内容的SHA-256（十六进制）
+	StorageUrl string    `gorm:"type:varchar(512);not null;unique"`                 // 存储地址
+	FileSize   int64     `gorm:"not null"`                                          // 对象大小（字节）
+	RefCount   int       `gorm:"not null;default:0"`                                // 引用该对象的分享（文件）数
+	CreatedAt  time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`                // 创建时间（自动记录）
+	UpdateAt   time.Time `gorm:"not null;default:CURRENT_TIMESTAMP;autoUpdateTime"` // 自动更新时间（GORM自动维护）
+}
+
+func (blob *Blob) TableName() string {
+	return "blob"
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+)
+
+// 下载事件结果
+const (
+	OutcomeSuccess           = "success"            // 取件成功
+	OutcomeCompleted         = "completed"          // 下载完成（由服务端根据传输字节数或存储后端访问事件判定）
+	OutcomeInvalidCode       = "invalid_code"       // 取件码格式错误
+	OutcomeNotFound          = "not_found"          // 取件码不存在
+	OutcomeExpired           = "expired"            // 分享已过期或下载次数已用尽
+	OutcomePasswordRequired  = "password_required"  // 未提供分享密码
+	OutcomePasswordIncorrect = "password_incorrect" // 分享密码错误
+	OutcomePasswordLocked    = "password_locked"    // 密码错误次数过多被锁定
+	OutcomeRateLimited       = "rate_limited"       // 取件尝试过多被限流
+	OutcomeError             = "error"              // 服务端错误
+)
+
+// DownloadEvent 下载事件，每次兑换取件码记录一条
+type DownloadEvent struct {
+	ID          uint      `gorm:"primaryKey"`
+	FileUuid    string    `gorm:"type:varchar(64);not null;default:'';index"` // 文件唯一标识（取件码不存在时为空）
+	UserID      uint      `gorm:"not null;default:0;index"`                   // 取件用户ID（0表示匿名取件）
+	DeviceHash  string    `gorm:"type:varchar(64);not null;default:'';index"` // 取件设备标识的SHA-256哈希
+	ClientIP    string    `gorm:"type:varchar(64);not null"`                  // 客户端IP
+	UserAgent   string    `gorm:"type:varchar(512);not null;default:''"`      // 客户端User-Agent
+	BytesServed int64     `gorm:"not null;default:0"`                         // 已传输字节数（0表示未知，如预签名直链下载）
+	Outcome     string    `gorm:"type:varchar(32);not null;index"`            // 结果
+	CreatedAt   time.Time `gorm:"not null;default:CURRENT_TIMESTAMP;index"`   // 发生时间
+}
+
+func (downloadEvent *DownloadEvent) TableName() string {
+	return "download_event"
+}
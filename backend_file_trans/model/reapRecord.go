@@ -0,0 +1,20 @@
+package model
+
+import (
+	"time"
+)
+
+// ReapRecord 过期清理记录，记录被清理的分享及其存储对象
+type ReapRecord struct {
+	ID         uint      `gorm:"primaryKey"`
+	FileUuid   string    `gorm:"type:varchar(64);not null;index"`    // 被清理的文件唯一标识
+	FileName   string    `gorm:"type:varchar(255);not null"`         // 文件名
+	StorageUrl string    `gorm:"type:varchar(512);not null"`         // 被删除的存储地址
+	Success    bool      `gorm:"not null;default:0"`                 // 存储对象是否删除成功
+	ErrMsg     string    `gorm:"type:varchar(512)"`                  // 删除失败时的错误信息
+	ReapedAt   time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"` // 清理时间
+}
+
+func (reapRecord *ReapRecord) TableName() string {
+	return "reap_record"
+}
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"time"
+)
+
+// ShareFile 多文件分享中的单个文件，每个文件单独存储，取件时逐个提供下载链接
+type ShareFile struct {
+	ID         uint      `gorm:"primaryKey"`
+	FileUuid   string    `gorm:"type:varchar(64);not null;index:idx_share_file_seq,unique"` // 所属分享的唯一标识
+	Seq        int       `gorm:"not null;index:idx_share_file_seq,unique"`                  // 文件在分享中的序号（从0开始）
+	FileName   string    `gorm:"type:varchar(255);not null"`                                // 文件名
+	Path       string    `gorm:"type:varchar(1024);not null;default:''"`                    // 文件在分享中的相对路径（保留上传时的目录结构，同一分享内不重复）
+	FileSize   int64     `gorm:"not null"`                                                  // 文件大小（字节）
+	MimeType   string    `gorm:"type:varchar(255);not null;default:''"`                     // 内容类型（根据文件头识别）
+	Category   string    `gorm:"type:varchar(32);not null;default:''"`                      // 文件分类
+	Sha256     string    `gorm:"type:char(64);not null;default:''"`                         // 文件内容的SHA-256（十六进制）
+	StorageUrl string    `gorm:"type:varchar(512);not null;index"`                          // 存储地址
+	CreatedAt  time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`                        // 创建时间（自动记录）
+}
+
+func (shareFile *ShareFile) TableName() string {
+	return "share_file"
+}
+
+// EntryPath 文件在压缩包及文件清单中的相对路径
+func (shareFile *ShareFile) EntryPath() string {
+	if shareFile.Path != "" {
+		return shareFile.Path
+	}
+	return shareFile.FileName
+}
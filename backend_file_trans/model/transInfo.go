@@ -7,16 +7,57 @@ import (
 type TransInfo struct {
 	ID            uint      `gorm:"primaryKey"`
 	FileUuid      string    `gorm:"type:varchar(64);not null;unique"`                  // 文件唯一标识
+	UserID        uint      `gorm:"not null;default:0;index"`                          // 发送者用户ID（0表示匿名发送）
+	SenderDevice  string    `gorm:"type:varchar(64);not null;default:'';index"`        // 发送者设备标识的SHA-256哈希（匿名发送时用于查询发送记录）
 	FileName      string    `gorm:"type:varchar(255);not null"`                        // 文件名
 	FileType      string    `gorm:"type:varchar(255);not null"`                        // 文件类型
 	FileSize      int64     `gorm:"not null"`                                          // 文件大小（字节）
-	StorageUrl    string    `gorm:"type:varchar(512);not null"`                        // MinIO存储地址
+	MimeType      string    `gorm:"type:varchar(255);not null;default:''"`             // 内容类型（根据文件头识别，多文件分享为打包下载的 application/zip）
+	Category      string    `gorm:"type:varchar(32);not null;default:''"`              // 文件分类（image/video/audio/text/pdf/document/archive/other）
+	Sha256        string    `gorm:"type:char(64);not null;default:''"`                 // 文件内容的SHA-256（十六进制，上传时计算；多文件分享见各文件）
+	StorageUrl    string    `gorm:"type:varchar(512);not null;index"`                  // MinIO存储地址（多文件分享为空，各文件的存储地址见 Files）
+	FileCount     int       `gorm:"not null;default:1"`                                // 文件数量（大于1为多文件分享）
 	IsExpire      bool      `gorm:"not null;default:0"`                                // 是否过期（0-未过期，1-已过期）
+	ExpireAt      time.Time `gorm:"type:datetime;default:NULL;index"`                  // 过期时间（发送时根据过期设置计算）
 	SendStatus    bool      `gorm:"not null;default:0"`                                // 发送状态（0-未发送，1-已发送）
 	ReceiveStatus bool      `gorm:"not null;default:0"`                                // 取件状态（0-未取件，1-已取件）
-	ReceiveAt     time.Time `gorm:"type:timestamp;default:NULL"`                       // 取件时间（默认NULL，取件时更新）
+	MaxDownloads  int       `gorm:"not null;default:0"`                                // 最大下载次数（0-不限，1-阅后即焚）
+	DownloadCount int       `gorm:"not null;default:0"`                                // 已下载次数
+	PasswordHash  string    `gorm:"type:varchar(255);not null;default:''"`             // 分享密码的bcrypt哈希（为空表示无密码）
+	ManageToken   string    `gorm:"type:varchar(64);not null;default:''"`              // 管理令牌的SHA-256哈希（发送者凭令牌撤销、延期）
+	IsRevoked     bool      `gorm:"not null;default:0"`                                // 是否已被发送者撤销
+	WrappedKey    string    `gorm:"type:varchar(128);not null;default:''"`             // 加密存储的数据密钥（以取件码与服务端密钥派生的密钥包装，为空表示未加密）
+	EncryptedMeta string    `gorm:"type:varchar(4096);not null;default:''"`            // 端到端加密分享的元数据（文件名、大小等，由客户端加密，服务端不解析）
+	TextContent   string    `gorm:"type:mediumtext;not null"`                          // 内联保存的文本内容（加密存储时为密文，过期或撤销后清空；为空表示文本写入了存储）
+	Language      string    `gorm:"type:varchar(32);not null;default:''"`              // 文本的语言提示（如 go、python），用于语法高亮
+	ReceiveAt     time.Time `gorm:"type:datetime;default:NULL"`                        // 取件时间（默认NULL，取件时更新）
 	CreatedAt     time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`                // 创建时间（自动记录）
 	UpdateAt      time.Time `gorm:"not null;default:CURRENT_TIMESTAMP;autoUpdateTime"` // 自动更新时间（GORM自动维护）
+
+	Files   []ShareFile `gorm:"foreignKey:FileUuid;references:FileUuid"` // 多文件分享的文件清单，随分享记录一起保存
+	DataKey []byte      `gorm:"-"`                                       // 解包后的数据密钥，仅在发送、取件及代理下载时存在于内存中
+
+	DownloadToken string `gorm:"-"` // 取件时生成的下载跟踪标识，仅随取件响应返回，用于校验下载的文件
+}
+
+// IsBundle 是否为多文件分享
+func (transInfo *TransInfo) IsBundle() bool {
+	return transInfo.FileCount > 1
+}
+
+// IsE2E 是否为端到端加密分享：存储的是客户端加密的密文，文件名为占位名称
+func (transInfo *TransInfo) IsE2E() bool {
+	return transInfo.FileType == "e2e"
+}
+
+// IsInline 是否为内联保存在数据库中的文本分享
+func (transInfo *TransInfo) IsInline() bool {
+	return transInfo.TextContent != ""
+}
+
+// IsEncrypted 存储对象是否加密存储
+func (transInfo *TransInfo) IsEncrypted() bool {
+	return transInfo.WrappedKey != ""
 }
 
 func (transInfo *TransInfo) TableName() string {
@@ -0,0 +1,18 @@
+package model
+
+import (
+	"time"
+)
+
+// User 注册用户
+type User struct {
+	ID           uint      `gorm:"primaryKey"`
+	Username     string    `gorm:"type:varchar(64);not null;unique"`                  // 用户名
+	PasswordHash string    `gorm:"type:varchar(255);not null"`                        // 登录密码的bcrypt哈希
+	CreatedAt    time.Time `gorm:"not null;default:CURRENT_TIMESTAMP"`                // 注册时间
+	UpdateAt     time.Time `gorm:"not null;default:CURRENT_TIMESTAMP;autoUpdateTime"` // 自动更新时间
+}
+
+func (user *User) TableName() string {
+	return "user"
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"daoke.com/file_trans/database"
+	"daoke.com/file_trans/model"
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type BlobDAO struct {
+	db *gorm.DB
+}
+
+// NewBlobDAO 创建一个新的 BlobDAO 实例
+func NewBlobDAO() *BlobDAO {
+	return &BlobDAO{
+		db: database.GetDB(),
+	}
+}
+
+// Acquire 增加内容哈希对应存储对象的引用计数，不存在时以 blob 中的存储地址新建（引用计数为1），
+// 返回实际引用的存储对象；INSERT ... ON DUPLICATE KEY UPDATE 保证并发上传相同内容时只保留一个对象
+func (b *BlobDAO) Acquire(blob *model.Blob) (*model.Blob, error) {
+	blob.RefCount = 1
+	err := b.db.Clauses(clause.OnConflict{
+		DoUpdates: clause.Assignments(map[string]interface{}{"ref_count": gorm.Expr("ref_count + 1")}),
+	}).Create(blob).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var stored model.Blob
+	result := b.db.Where("sha256 = ?", blob.Sha256).First(&stored)
+	return &stored, result.Error
+}
+
+// Release 减少存储对象的引用计数，归零时删除记录；返回存储对象是否已无引用（可以删除），
+// 不在 blob 表中的对象（启用去重前上传的）视为无引用
+func (b *BlobDAO) Release(storageURL string) (bool, error) {
+	unreferenced := false
+	err := b.db.Transaction(func(tx *gorm.DB) error {
+		var blob model.Blob
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("storage_url = ?", storageURL).First(&blob).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			unreferenced = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if blob.RefCount <= 1 {
+			unreferenced = true
+			return tx.Delete(&blob).Error
+		}
+		return tx.Model(&blob).Update("ref_count", gorm.Expr("ref_count - 1")).Error
+	})
+	return unreferenced, err
+}
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"daoke.com/file_trans/database"
+	"daoke.com/file_trans/model"
+	"gorm.io/gorm"
+)
+
+type DownloadEventDAO struct {
+	db *gorm.DB
+}
+
+// NewDownloadEventDAO 创建一个新的 DownloadEventDAO 实例
+func NewDownloadEventDAO() *DownloadEventDAO {
+	return &DownloadEventDAO{
+		db: database.GetDB(),
+	}
+}
+
+// Create 保存下载事件
+func (d *DownloadEventDAO) Create(event *model.DownloadEvent) error {
+	return d.db.Create(event).Error
+}
+
+// QueryByFileUUID 按时间倒序查询文件的下载事件
+func (d *DownloadEventDAO) QueryByFileUUID(fileUUID string, limit int) ([]model.DownloadEvent, error) {
+	var events []model.DownloadEvent
+	result := d.db.Where("file_uuid = ?", fileUUID).
+		Order("created_at desc, id desc").
+		Limit(limit).
+		Find(&events)
+	return events, result.Error
+}
+
+// CountByOutcome 统计文件各结果的下载事件数量
+func (d *DownloadEventDAO) CountByOutcome(fileUUID string) (map[string]int64, error) {
+	var rows []struct {
+		Outcome string
+		Count   int64
+	}
+	result := d.db.Model(&model.DownloadEvent{}).
+		Select("outcome, count(*) as count").
+		Where("file_uuid = ?", fileUUID).
+		Group("outcome").
+		Scan(&rows)
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Outcome] = row.Count
+	}
+	return counts, result.Error
+}
@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"daoke.com/file_trans/database"
+	"daoke.com/file_trans/model"
+	"gorm.io/gorm"
+)
+
+type ReapRecordDAO struct {
+	db *gorm.DB
+}
+
+// NewReapRecordDAO 创建一个新的 ReapRecordDAO 实例
+func NewReapRecordDAO() *ReapRecordDAO {
+	return &ReapRecordDAO{
+		db: database.GetDB(),
+	}
+}
+
+// Create 保存过期清理记录
+func (r *ReapRecordDAO) Create(record *model.ReapRecord) error {
+	return r.db.Create(record).Error
+}
@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"daoke.com/file_trans/database"
+	"daoke.com/file_trans/model"
+	"gorm.io/gorm"
+)
+
+type ShareFileDAO struct {
+	db *gorm.DB
+}
+
+// NewShareFileDAO 创建一个新的 ShareFileDAO 实例
+func NewShareFileDAO() *ShareFileDAO {
+	return &ShareFileDAO{
+		db: database.GetDB(),
+	}
+}
+
+// ListByFileUUID 按序号查询多文件分享的文件清单
+func (s *ShareFileDAO) ListByFileUUID(fileUUID string) ([]model.ShareFile, error) {
+	var files []model.ShareFile
+	result := s.db.Where("file_uuid = ?", fileUUID).Order("seq asc").Find(&files)
+	return files, result.Error
+}
+
+// GetBySeq 查询多文件分享中指定序号的文件
+func (s *ShareFileDAO) GetBySeq(fileUUID string, seq int) (*model.ShareFile, error) {
+	var file model.ShareFile
+	result := s.db.Where("file_uuid = ? AND seq = ?", fileUUID, seq).First(&file)
+	return &file, result.Error
+}
@@ -3,7 +3,9 @@ package repository
 import (
 	"daoke.com/file_trans/database"
 	"daoke.com/file_trans/model"
+	"fmt"
 	"gorm.io/gorm"
+	"strings"
 	"time"
 )
 
@@ -30,14 +32,6 @@ func (t *TransInfoDAO) GetByUUID(uuid string) (*model.TransInfo, error) {
 	return &transInfo, result.Error
 }
 
-// UpdateSendStatus 标记文件为"已发送"
-func (t *TransInfoDAO) UpdateSendStatus(fileUUID string) error {
-	return t.db.Model(&model.TransInfo{}).
-		Where("file_uuid = ?", fileUUID).
-		Update("send_status", true). // 发送成功后更新为true
-		Error
-}
-
 // UpdateReceiveInfo 取件成功后更新状态和时间
 func (t *TransInfoDAO) UpdateReceiveInfo(fileUUID string) error {
 	return t.db.Model(&model.TransInfo{}).
@@ -48,18 +42,194 @@ func (t *TransInfoDAO) UpdateReceiveInfo(fileUUID string) error {
 		}).Error
 }
 
-// QuerySendRecords 查询今天发送的记录
-func (t *TransInfoDAO) QuerySendRecords() ([]model.TransInfo, error) {
+// IncrementDownloadCount 下载次数加一
+func (t *TransInfoDAO) IncrementDownloadCount(fileUUID string) error {
+	return t.db.Model(&model.TransInfo{}).
+		Where("file_uuid = ?", fileUUID).
+		Update("download_count", gorm.Expr("download_count + 1")).
+		Error
+}
+
+// UpdateSha256 写入异步计算的文件SHA-256
+func (t *TransInfoDAO) UpdateSha256(fileUUID, sha256 string) error {
+	return t.db.Model(&model.TransInfo{}).Where("file_uuid = ?", fileUUID).Update("sha256", sha256).Error
+}
+
+// UpdateExpireAt 更新过期时间
+func (t *TransInfoDAO) UpdateExpireAt(fileUUID string, expireAt time.Time) error {
+	return t.db.Model(&model.TransInfo{}).
+		Where("file_uuid = ?", fileUUID).
+		Update("expire_at", expireAt).
+		Error
+}
+
+// 记录排序字段
+const (
+	RecordSortTime = "time" // 按时间（发送记录为发送时间，取件记录为取件时间）
+	RecordSortSize = "size" // 按文件大小
+)
+
+// 记录状态筛选
+const (
+	RecordStatusActive  = "active"  // 有效
+	RecordStatusExpired = "expired" // 已过期（含下载次数用尽）
+	RecordStatusRevoked = "revoked" // 已撤销
+)
+
+// RecordCursor 游标分页位置：上一页最后一条记录的排序值和ID
+type RecordCursor struct {
+	Time time.Time `json:"t,omitempty"`
+	Size int64     `json:"s,omitempty"`
+	ID   uint      `json:"id"`
+}
+
+// RecordFilter 记录查询条件
+type RecordFilter struct {
+	UserID     uint          // 登录用户ID（0表示匿名，按设备标识查询）
+	DeviceHash string        // 设备标识哈希
+	From       time.Time     // 起始时间（含），零值表示不限
+	To         time.Time     // 截止时间（不含），零值表示不限
+	FileType   string        // 类型（text/file/e2e），为空表示不限
+	Keyword    string        // 文件名关键字
+	Status     string        // 状态，为空表示不限
+	SortBy     string        // 排序字段
+	Asc        bool          // 是否升序
+	Limit      int           // 每页条数
+	Cursor     *RecordCursor // 分页游标，nil表示第一页
+}
+
+// ReceivedTransInfo 取件记录：分享信息及取件事件
+type ReceivedTransInfo struct {
+	model.TransInfo
+	EventID    uint
+	ReceivedAt time.Time
+}
+
+// QuerySendRecords 按条件分页查询调用方发送的记录（登录用户按用户ID，匿名用户按设备标识）
+func (t *TransInfoDAO) QuerySendRecords(filter *RecordFilter) ([]model.TransInfo, error) {
 	var transInfos []model.TransInfo
-	// 查询 send_status = 1 并且 created_at 大于等于 今天0点 的记录
-	result := t.db.Where("created_at >= ? and send_status = ?", time.Now().Truncate(24*time.Hour), true).Find(&transInfos)
+	query := t.db.Where("send_status = ?", true)
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	} else {
+		query = query.Where("user_id = 0 and sender_device = ?", filter.DeviceHash)
+	}
+	query = applyRecordFilter(query, filter, "trans_info.created_at", "trans_info.id")
+	result := query.Find(&transInfos)
 	return transInfos, result.Error
 }
 
-// QueryReceiveRecords 查询今天取件的记录
-func (t *TransInfoDAO) QueryReceiveRecords() ([]model.TransInfo, error) {
-	// 查询 receive_status = 1 并且 created_at 大于等于 今天0点 的记录
+// QueryReceiveRecords 按条件分页查询调用方成功取件的记录（每次成功取件为一条，根据下载事件关联分享）
+func (t *TransInfoDAO) QueryReceiveRecords(filter *RecordFilter) ([]ReceivedTransInfo, error) {
+	var records []ReceivedTransInfo
+	query := t.db.Table("download_event").
+		Select("trans_info.*, download_event.id as event_id, download_event.created_at as received_at").
+		Joins("join trans_info on trans_info.file_uuid = download_event.file_uuid").
+		Where("download_event.outcome = ?", model.OutcomeSuccess)
+	if filter.UserID != 0 {
+		query = query.Where("download_event.user_id = ?", filter.UserID)
+	} else {
+		query = query.Where("download_event.user_id = 0 and download_event.device_hash = ?", filter.DeviceHash)
+	}
+	query = applyRecordFilter(query, filter, "download_event.created_at", "download_event.id")
+	result := query.Scan(&records)
+	return records, result.Error
+}
+
+// applyRecordFilter 添加时间范围、类型、关键字、状态筛选以及排序和游标分页条件
+func applyRecordFilter(query *gorm.DB, filter *RecordFilter, timeColumn, idColumn string) *gorm.DB {
+	if !filter.From.IsZero() {
+		query = query.Where(timeColumn+" >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where(timeColumn+" < ?", filter.To)
+	}
+	if filter.FileType != "" {
+		query = query.Where("trans_info.file_type = ?", filter.FileType)
+	}
+	if filter.Keyword != "" {
+		// 端到端加密分享的文件名是占位名称，真实文件名只在加密元数据中，不参与关键字匹配
+		query = query.Where("trans_info.file_type <> ? and trans_info.file_name like ?", "e2e", "%"+escapeLike(filter.Keyword)+"%")
+	}
+
+	now := time.Now()
+	switch filter.Status {
+	case RecordStatusActive:
+		query = query.Where("trans_info.is_revoked = ? and trans_info.is_expire = ? and (trans_info.expire_at is null or trans_info.expire_at > ?)",
+			false, false, now)
+	case RecordStatusExpired:
+		query = query.Where("trans_info.is_revoked = ? and (trans_info.is_expire = ? or trans_info.expire_at <= ?)",
+			false, true, now)
+	case RecordStatusRevoked:
+		query = query.Where("trans_info.is_revoked = ?", true)
+	}
+
+	sortColumn := timeColumn
+	if filter.SortBy == RecordSortSize {
+		sortColumn = "trans_info.file_size"
+	}
+	op, order := "<", "desc"
+	if filter.Asc {
+		op, order = ">", "asc"
+	}
+
+	// 游标分页：取排序值和ID都在上一页最后一条之后的记录，翻页过程中有新记录写入也不会重复或遗漏
+	if cursor := filter.Cursor; cursor != nil {
+		var value interface{} = cursor.Time
+		if filter.SortBy == RecordSortSize {
+			value = cursor.Size
+		}
+		query = query.Where(fmt.Sprintf("(%s %s ? or (%s = ? and %s %s ?))", sortColumn, op, sortColumn, idColumn, op),
+			value, value, cursor.ID)
+	}
+
+	return query.Order(sortColumn + " " + order).Order(idColumn + " " + order).Limit(filter.Limit)
+}
+
+// escapeLike 转义 LIKE 查询中的通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// FindExpired 查询已到过期时间但尚未标记过期的记录
+func (t *TransInfoDAO) FindExpired(now time.Time, limit int) ([]model.TransInfo, error) {
 	var transInfos []model.TransInfo
-	result := t.db.Where("created_at >= ? and receive_status = ?", time.Now().Truncate(24*time.Hour), true).Find(&transInfos)
+	result := t.db.Where("is_expire = ? and expire_at <= ?", false, now).
+		Order("expire_at").
+		Limit(limit).
+		Find(&transInfos)
 	return transInfos, result.Error
 }
+
+// MarkExpired 将记录标记为已过期并清空内联文本，返回是否由本次调用完成标记（用于多实例并发时的抢占）；
+// 调用方只有在完成标记时才能释放存储对象
+func (t *TransInfoDAO) MarkExpired(id uint) (bool, error) {
+	return t.claimExpire(id, map[string]interface{}{})
+}
+
+// MarkRevoked 将记录标记为已撤销并过期，同时清空内联文本，返回是否由本次调用完成过期标记；
+// 记录已过期（如已被过期清理任务处理）时只标记撤销，返回 false
+func (t *TransInfoDAO) MarkRevoked(id uint) (bool, error) {
+	claimed, err := t.claimExpire(id, map[string]interface{}{
+		"is_revoked": true,
+		"expire_at":  time.Now(),
+	})
+	if err != nil || claimed {
+		return claimed, err
+	}
+	return false, t.db.Model(&model.TransInfo{}).
+		Where("id = ? and is_revoked = ?", id, false).
+		Update("is_revoked", true).
+		Error
+}
+
+// claimExpire 以 is_expire=false 为条件将记录标记为过期并清空内联文本（updates 为同时更新的字段），返回是否影响了记录；
+// 过期清理与撤销共用该条件更新，保证同一分享的存储对象只被释放一次
+func (t *TransInfoDAO) claimExpire(id uint, updates map[string]interface{}) (bool, error) {
+	updates["is_expire"] = true
+	updates["text_content"] = ""
+	result := t.db.Model(&model.TransInfo{}).
+		Where("id = ? and is_expire = ?", id, false).
+		Updates(updates)
+	return result.RowsAffected == 1, result.Error
+}
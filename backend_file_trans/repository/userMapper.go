@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"daoke.com/file_trans/database"
+	"daoke.com/file_trans/model"
+	"gorm.io/gorm"
+)
+
+type UserDAO struct {
+	db *gorm.DB
+}
+
+// NewUserDAO 创建一个新的 UserDAO 实例
+func NewUserDAO() *UserDAO {
+	return &UserDAO{
+		db: database.GetDB(),
+	}
+}
+
+// Create 保存用户
+func (u *UserDAO) Create(user *model.User) error {
+	return u.db.Create(user).Error
+}
+
+// GetByUsername 根据用户名查询用户
+func (u *UserDAO) GetByUsername(username string) (*model.User, error) {
+	var user model.User
+	result := u.db.Where("username = ?", username).First(&user)
+	return &user, result.Error
+}
+
+// GetByID 根据ID查询用户
+func (u *UserDAO) GetByID(id uint) (*model.User, error) {
+	var user model.User
+	result := u.db.First(&user, id)
+	return &user, result.Error
+}
@@ -1,21 +1,27 @@
 package router
 
 import (
+	"daoke.com/file_trans/conf"
 	"daoke.com/file_trans/controller"
 	"daoke.com/file_trans/logger"
 	"github.com/gin-gonic/gin"
+	"log"
 	"time"
 )
 
 // InitRouter 初始化路由
 func InitRouter() *gin.Engine {
 	r := gin.Default()
+	// 只采信配置的反向代理转发的 X-Forwarded-For，否则客户端可伪造IP绕过取件防爆破
+	if err := r.SetTrustedProxies(conf.AppConfig.Server.TrustedProxies); err != nil {
+		log.Fatalf("配置信任的反向代理失败：%v", err)
+	}
 	r.Use(func(ctx *gin.Context) {
 		//中间件
 		ctx.Header("Access-Control-Allow-Origin", "*")
-		ctx.Header("Access-Control-Allow-Methods", "GET,POST,PUT,DELETE,OPTIONS")
-		ctx.Header("Access-Control-Allow-Headers", "Origin,Content-Type,Content-Length,Accept-Encoding,X-CSRF-Token,Authorization")
-		ctx.Header("Access-Control-Expose-Headers", "Content-Length")
+		ctx.Header("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
+		ctx.Header("Access-Control-Allow-Headers", "Origin,Content-Type,Content-Length,Accept-Encoding,X-CSRF-Token,Authorization,X-Share-Password,X-Manage-Token,X-Device-Id")
+		ctx.Header("Access-Control-Expose-Headers", "Content-Length,Content-Disposition,Content-Range,Accept-Ranges,ETag,X-Device-Id,X-Checksum-Sha256,Repr-Digest")
 		ctx.Header("Access-Control-Allow-Credentials", "true")
 		// 预处理请求
 		if ctx.Request.Method == "OPTIONS" {
@@ -27,11 +33,54 @@ func InitRouter() *gin.Engine {
 	// 创建发送控制器
 	sendController := controller.NewSendController()
 	receiveController := controller.NewReceiveController()
+	uploadController := controller.NewUploadController()
+	storageController := controller.NewStorageController()
+	manageController := controller.NewManageController()
+	authController := controller.NewAuthController()
+	downloadController := controller.NewDownloadController()
 
-	v1 := r.Group("api/v1")
+	v1 := r.Group("api/v1", authController.Authenticate, authController.IdentifyDevice)
 	{
+		// 用户注册、登录
+		auth := v1.Group("/auth")
+		{
+			auth.POST("/register", authController.Register)
+			auth.POST("/login", authController.Login)
+			auth.POST("/refresh", authController.Refresh)
+			auth.POST("/logout", authController.Logout)
+			auth.GET("/me", authController.RequireLogin, authController.Me)
+		}
+
 		// 发送文件
-		v1.POST("/sendPackage", sendController.Send)
+		v1.POST("/sendPackage", authController.RequireSender, sendController.LimitRequestBody, sendController.Send)
+
+		// 分片上传（断点续传）
+		upload := v1.Group("/upload", authController.RequireSender, uploadController.RequireStorageSupport)
+		{
+			upload.POST("/init", uploadController.InitUpload)
+			upload.GET("/:uploadId", uploadController.GetUploadStatus)
+			upload.PUT("/:uploadId/parts/:partNumber", uploadController.UploadPart)
+			upload.POST("/:uploadId/complete", uploadController.CompleteUpload)
+			upload.DELETE("/:uploadId", uploadController.AbortUpload)
+		}
+
+		// 直传对象存储（预签名上传）
+		presign := v1.Group("/presign", authController.RequireSender, uploadController.RequireStorageSupport)
+		{
+			presign.POST("/init", uploadController.PresignUpload)
+			presign.POST("/:uploadId/confirm", uploadController.ConfirmPresignedUpload)
+		}
+
+		// 本地存储签名下载链接（仅本地存储后端）
+		if storageController.LocalStore != nil {
+			v1.GET("/storage/download", storageController.Download)
+		}
+
+		// 代理下载（download.mode 为 proxy 时下载链接指向此处，多文件分享的打包下载及加密存储的分享总是指向此处）
+		v1.GET("/download/:token", downloadController.Download)
+		v1.HEAD("/download/:token", downloadController.Download)
+		v1.GET("/download/:token/files/:seq", downloadController.DownloadFile)
+		v1.HEAD("/download/:token/files/:seq", downloadController.DownloadFile)
 
 		// 发送记录
 		v1.GET("/sendRecords", sendController.QuerySendRecords)
@@ -39,11 +88,28 @@ func InitRouter() *gin.Engine {
 		// 下载文件
 		v1.GET("/receivePackage", receiveController.Receive)
 
-		// 更新文件状态
-		v1.POST("/updateFileStatus", receiveController.UpdateFileStatus)
+		// 以纯文本返回文本分享的内容（命令行取件，非内联文本重定向到下载链接）
+		v1.GET("/raw/:code", receiveController.Raw)
+
+		// 校验下载文件的SHA-256（凭取件时返回的 downloadToken）
+		v1.GET("/verify/:token", receiveController.VerifyChecksum)
 
 		// 取件记录
 		v1.GET("/receiveRecords", receiveController.QueryReceiveRecords)
+
+		// 分享管理（凭发送时返回的管理令牌）
+		shares := v1.Group("/shares/:fileUuid")
+		{
+			shares.GET("", manageController.GetShare)
+			shares.DELETE("", manageController.RevokeShare)
+			shares.PATCH("/expiry", manageController.UpdateExpiry)
+			shares.GET("/events", manageController.QueryDownloadEvents)
+		}
+	}
+
+	// MinIO存储桶访问通知，使用独立的认证令牌，不经过用户认证中间件
+	if conf.AppConfig.Download.NotifyToken != "" {
+		r.POST("/api/v1/storage/events", downloadController.StorageEvents)
 	}
 	return r
 }
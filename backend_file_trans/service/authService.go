@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"daoke.com/file_trans/conf"
+	"daoke.com/file_trans/database"
+	"daoke.com/file_trans/model"
+	"daoke.com/file_trans/repository"
+	"daoke.com/file_trans/utils"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"strconv"
+	"time"
+)
+
+// 令牌类型，防止刷新令牌被当作访问令牌使用
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+// refreshTokenKeyPrefix 有效刷新令牌在Redis中的键前缀（值为用户ID），刷新或注销后删除
+const refreshTokenKeyPrefix = "refresh:"
+
+// errJWTSecretMissing 未配置JWT签名密钥
+var errJWTSecretMissing = errors.New("auth jwt_secret is required")
+
+// Claims JWT载荷
+type Claims struct {
+	UserID    uint   `json:"uid"`
+	Username  string `json:"name"`
+	TokenType string `json:"typ"`
+	jwt.RegisteredClaims
+}
+
+// TokenPair 登录或刷新后返回的令牌
+type TokenPair struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int    `json:"expiresIn"` // 访问令牌有效期（秒）
+}
+
+// AuthService 用户注册、登录及JWT令牌的签发与校验
+type AuthService struct {
+	userDB  *repository.UserDAO
+	rClient *redis.Client
+}
+
+// NewAuthService 创建一个新的 AuthService 实例
+func NewAuthService() *AuthService {
+	return &AuthService{
+		userDB:  repository.NewUserDAO(),
+		rClient: database.RClient,
+	}
+}
+
+// Register 注册用户，密码以bcrypt哈希保存；用户名由唯一索引保证不重复，并发注册同一用户名时只有一个成功
+func (a *AuthService) Register(username, password string) (*model.User, error) {
+	// 提前检查用户名是否已存在，避免无谓的bcrypt计算
+	if _, err := a.userDB.GetByUsername(username); err == nil {
+		return nil, ErrUserExists
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	passwordHash, err := utils.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	user := &model.User{
+		Username:     username,
+		PasswordHash: passwordHash,
+	}
+	if err := a.userDB.Create(user); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrUserExists
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// Login 校验用户名和密码，成功后签发令牌
+func (a *AuthService) Login(username, password string) (*model.User, *TokenPair, error) {
+	user, err := a.userDB.GetByUsername(username)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidCredentials
+		}
+		return nil, nil, err
+	}
+	if !utils.CheckPassword(user.PasswordHash, password) {
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	tokens, err := a.issueTokens(user)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, tokens, nil
+}
+
+// Refresh 使用刷新令牌换取新的令牌；刷新令牌只能使用一次，换取后旧令牌立即失效
+func (a *AuthService) Refresh(refreshToken string) (*TokenPair, error) {
+	claims, err := a.ParseToken(refreshToken, TokenTypeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	// GETDEL 保证并发刷新时同一个刷新令牌只有一次能成功
+	if err := a.rClient.GetDel(context.Background(), refreshTokenKeyPrefix+claims.ID).Err(); err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+
+	user, err := a.userDB.GetByID(claims.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	return a.issueTokens(user)
+}
+
+// Logout 注销刷新令牌（访问令牌在有效期内仍可使用，有效期较短）
+func (a *AuthService) Logout(refreshToken string) error {
+	claims, err := a.ParseToken(refreshToken, TokenTypeRefresh)
+	if err != nil {
+		return err
+	}
+	return a.rClient.Del(context.Background(), refreshTokenKeyPrefix+claims.ID).Err()
+}
+
+// ParseToken 校验令牌签名、有效期及类型
+func (a *AuthService) ParseToken(tokenString, tokenType string) (*Claims, error) {
+	authConf := conf.AppConfig.Auth
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if authConf.JWTSecret == "" {
+			return nil, errJWTSecretMissing
+		}
+		return []byte(authConf.JWTSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(authConf.Issuer))
+	if err != nil || claims.TokenType != tokenType {
+		return nil, ErrTokenInvalid
+	}
+	return claims, nil
+}
+
+// issueTokens 签发访问令牌和刷新令牌，刷新令牌的ID记录在Redis中以支持轮换和注销
+func (a *AuthService) issueTokens(user *model.User) (*TokenPair, error) {
+	authConf := conf.AppConfig.Auth
+	accessToken, _, err := a.signToken(user, TokenTypeAccess, authConf.AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, refreshID, err := a.signToken(user, TokenTypeRefresh, authConf.RefreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	key := refreshTokenKeyPrefix + refreshID
+	if err := a.rClient.Set(context.Background(), key, user.ID, authConf.RefreshTokenTTL).Err(); err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(authConf.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// signToken 使用HS256签发指定类型的令牌，返回令牌及其ID
+func (a *AuthService) signToken(user *model.User, tokenType string, ttl time.Duration) (string, string, error) {
+	authConf := conf.AppConfig.Auth
+	if authConf.JWTSecret == "" {
+		return "", "", errJWTSecretMissing
+	}
+
+	now := time.Now()
+	id := uuid.New().String()
+	claims := &Claims{
+		UserID:    user.ID,
+		Username:  user.Username,
+		TokenType: tokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			Issuer:    authConf.Issuer,
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(authConf.JWTSecret))
+	if err != nil {
+		return "", "", err
+	}
+	return signed, id, nil
+}
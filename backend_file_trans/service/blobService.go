@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"daoke.com/file_trans/logger"
+	"daoke.com/file_trans/model"
+	"daoke.com/file_trans/repository"
+	"daoke.com/file_trans/storage"
+)
+
+// BlobService 按内容哈希去重存储对象：内容相同的上传共用一个存储对象，
+// 分享创建时增加引用计数，过期或撤销时减少，最后一个引用释放后才删除存储对象。
+// 只有经后端表单上传、未加密的文件参与去重：分片上传与直传的内容不经过后端，SHA-256 在分享创建后才计算，
+// 加密对象的密文各不相同；这些对象在 blob 表中没有记录，释放时直接删除
+type BlobService struct {
+	blobDB *repository.BlobDAO
+}
+
+// NewBlobService 创建一个新的 BlobService 实例
+func NewBlobService() *BlobService {
+	return &BlobService{
+		blobDB: repository.NewBlobDAO(),
+	}
+}
+
+// Acquire 登记刚上传的对象并增加引用计数；内容已存在时删除新上传的对象，
+// 将 result.URL 替换为已有对象的存储地址
+func (b *BlobService) Acquire(ctx context.Context, result *UploadResult) error {
+	blob, err := b.blobDB.Acquire(&model.Blob{
+		Sha256:     result.Sha256,
+		StorageUrl: result.URL,
+		FileSize:   result.Size,
+	})
+	if err != nil {
+		return err
+	}
+	if blob.StorageUrl == result.URL {
+		return nil
+	}
+
+	if err := storage.DeleteStorageURL(ctx, result.URL); err != nil {
+		logger.Error("删除重复上传的对象失败", "err", err, "storageUrl", result.URL)
+	}
+	logger.Info("文件内容已存在，复用已有存储对象", "sha256", result.Sha256, "storageUrl", blob.StorageUrl)
+	result.URL = blob.StorageUrl
+	result.Deduplicated = true
+	return nil
+}
+
+// Release 释放对存储对象的一次引用，没有其他分享引用时删除存储对象
+func (b *BlobService) Release(ctx context.Context, storageURL string) error {
+	if storageURL == "" {
+		return nil
+	}
+	unreferenced, err := b.blobDB.Release(storageURL)
+	if err != nil || !unreferenced {
+		return err
+	}
+	return storage.DeleteStorageURL(ctx, storageURL)
+}
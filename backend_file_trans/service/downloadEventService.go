@@ -0,0 +1,47 @@
+package service
+
+import (
+	"daoke.com/file_trans/logger"
+	"daoke.com/file_trans/model"
+	"daoke.com/file_trans/repository"
+	"strings"
+	"time"
+)
+
+// maxDownloadEvents 单次查询返回的最大下载事件数
+const maxDownloadEvents = 200
+
+type DownloadEventService struct {
+	downloadEventDB *repository.DownloadEventDAO
+}
+
+// NewDownloadEventService 创建一个新的 DownloadEventService 实例
+func NewDownloadEventService() *DownloadEventService {
+	return &DownloadEventService{
+		downloadEventDB: repository.NewDownloadEventDAO(),
+	}
+}
+
+// Record 记录一次下载事件，失败只记日志，不影响取件
+func (d *DownloadEventService) Record(event *model.DownloadEvent) {
+	if len(event.UserAgent) > 512 {
+		event.UserAgent = strings.ToValidUTF8(event.UserAgent[:512], "")
+	}
+	event.CreatedAt = time.Now()
+	if err := d.downloadEventDB.Create(event); err != nil {
+		logger.Error("保存下载事件失败", "err", err, "fileUuid", event.FileUuid, "outcome", event.Outcome)
+	}
+}
+
+// QueryEvents 查询文件的下载事件及按结果汇总的次数
+func (d *DownloadEventService) QueryEvents(fileUUID string) ([]model.DownloadEvent, map[string]int64, error) {
+	events, err := d.downloadEventDB.QueryByFileUUID(fileUUID, maxDownloadEvents)
+	if err != nil {
+		return nil, nil, err
+	}
+	counts, err := d.downloadEventDB.CountByOutcome(fileUUID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return events, counts, nil
+}
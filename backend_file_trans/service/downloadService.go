@@ -0,0 +1,406 @@
+package service
+
+import (
+	"context"
+	"daoke.com/file_trans/conf"
+	"daoke.com/file_trans/database"
+	"daoke.com/file_trans/logger"
+	"daoke.com/file_trans/model"
+	"daoke.com/file_trans/repository"
+	"daoke.com/file_trans/storage"
+	"daoke.com/file_trans/utils"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// 下载方式
+const (
+	DownloadModePresign = "presign" // 预签名直链，客户端直接访问存储后端
+	DownloadModeProxy   = "proxy"   // 后端代理下载，存储后端无需对外暴露
+)
+
+// DownloadPath 代理下载路径前缀，后接下载令牌
+const DownloadPath = "/api/v1/download/"
+
+// 下载相关的Redis键前缀
+const (
+	downloadTokenKeyPrefix   = "dl:"         // 代理下载令牌（值为fileUuid），有效期内可多次请求以支持断点续传
+	downloadTrackKeyPrefix   = "dl_track:"   // 取件方下载链接的传输统计（file：fileUuid，size：文件大小，files：文件数，bytes：已传输字节数，done：是否已完成）
+	downloadPendingKeyPrefix = "dl_pending:" // 限次数分享已发出、尚未下载完成的下载链接数
+)
+
+// trackAllPart 整个分享（单个文件或多文件分享的打包下载）的传输统计项
+const trackAllPart = "all"
+
+// trackScript 累加下载链接已传输的字节数（KEYS[1]为统计键，ARGV[1]为本次传输字节数，ARGV[2]为该项大小，
+// ARGV[3]为统计项：all 或多文件分享中的文件序号）：未跟踪的链接返回空；
+// 整个分享传输完整，或多文件分享的每个文件都传输完整时返回 {fileUuid, 累计字节数}，之后不再重复返回
+var trackScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return false
+end
+local total = redis.call("HINCRBY", KEYS[1], "bytes", ARGV[1])
+local served = redis.call("HINCRBY", KEYS[1], "bytes:" .. ARGV[3], ARGV[1])
+if served < tonumber(ARGV[2]) or redis.call("HSETNX", KEYS[1], "done:" .. ARGV[3], 1) == 0 then
+	return false
+end
+local required = 1
+if ARGV[3] ~= "all" then
+	required = tonumber(redis.call("HGET", KEYS[1], "files"))
+end
+if redis.call("HINCRBY", KEYS[1], "parts", 1) < required or redis.call("HSETNX", KEYS[1], "done", 1) == 0 then
+	return false
+end
+return {redis.call("HGET", KEYS[1], "file"), total}
+`)
+
+// pendingDoneScript 一个下载链接下载完成，减少尚未完成的下载链接数（KEYS[1]为计数键），返回剩余数；
+// 计数已过期（链接均已失效）时返回0，归零时删除计数键
+var pendingDoneScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+local pending = redis.call("DECR", KEYS[1])
+if pending <= 0 then
+	redis.call("DEL", KEYS[1])
+end
+return pending
+`)
+
+// DownloadURL 根据配置的下载方式生成有时效的下载链接：预签名直链或后端代理下载链接；
+// 多文件分享返回打包下载全部文件的代理下载链接
+func DownloadURL(ctx context.Context, transInfo *model.TransInfo) (string, error) {
+	link, _, err := issueDownloadURL(ctx, transInfo)
+	return link, err
+}
+
+// trackedDownloadURL 为取件方生成下载链接并登记传输统计，传输完成后由服务端标记取件完成；返回下载链接及跟踪标识
+func trackedDownloadURL(ctx context.Context, transInfo *model.TransInfo) (string, string, error) {
+	link, trackingID, err := issueDownloadURL(ctx, transInfo)
+	if err != nil || trackingID == "" {
+		return link, trackingID, err
+	}
+
+	key := downloadTrackKeyPrefix + trackingID
+	pipe := database.RClient.TxPipeline()
+	pipe.HSet(ctx, key, "file", transInfo.FileUuid, "size", transInfo.FileSize, "files", transInfo.FileCount, "bytes", 0)
+	pipe.Expire(ctx, key, DownloadURLExpiry)
+	if transInfo.MaxDownloads > 0 {
+		pipe.Incr(ctx, downloadPendingKeyPrefix+transInfo.FileUuid)
+		pipe.Expire(ctx, downloadPendingKeyPrefix+transInfo.FileUuid, DownloadURLExpiry)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", "", err
+	}
+	return link, trackingID, nil
+}
+
+// issueDownloadURL 生成下载链接（内联文本返回空），并返回用于统计传输字节数的跟踪标识：
+// 直链附带跟踪标识，MinIO预签名直链不经过后端，由存储后端的审计日志统计，本地存储的签名链接由后端统计；
+// 多文件分享需由后端打包、加密存储的分享需由后端解密，总是生成代理下载链接，跟踪标识即下载令牌
+func issueDownloadURL(ctx context.Context, transInfo *model.TransInfo) (string, string, error) {
+	// 内联保存的文本随取件响应返回，没有下载链接
+	if transInfo.IsInline() {
+		return "", "", nil
+	}
+	if !requiresProxy(transInfo) && !transInfo.IsBundle() {
+		trackingID, err := utils.GenerateToken()
+		if err != nil {
+			return "", "", err
+		}
+		link, err := storage.PresignTrackedURL(ctx, transInfo.StorageUrl, transInfo.FileName, trackingID, DownloadURLExpiry)
+		return link, trackingID, err
+	}
+
+	token, err := utils.GenerateToken()
+	if err != nil {
+		return "", "", err
+	}
+	if transInfo.IsEncrypted() {
+		if err := saveDownloadKey(ctx, token, transInfo.DataKey); err != nil {
+			return "", "", err
+		}
+	}
+	if err := database.RClient.Set(ctx, downloadTokenKeyPrefix+token, transInfo.FileUuid, DownloadURLExpiry).Err(); err != nil {
+		return "", "", err
+	}
+	return proxyDownloadURL(token), token, nil
+}
+
+// requiresProxy 是否必须由后端代理下载：配置为代理下载，或存储对象加密存储
+func requiresProxy(transInfo *model.TransInfo) bool {
+	return conf.AppConfig.Download.Mode == DownloadModeProxy || transInfo.IsEncrypted()
+}
+
+// bundleFileURL 生成多文件分享中单个文件的下载链接，downloadToken 为整个分享的代理下载令牌；
+// 单个文件的下载计入同一令牌的传输统计，打包下载完成或每个文件都下载完成时视为取件完成
+func bundleFileURL(ctx context.Context, transInfo *model.TransInfo, downloadToken string, file *model.ShareFile) (string, error) {
+	if !requiresProxy(transInfo) {
+		return storage.PresignTrackedURL(ctx, file.StorageUrl, file.FileName, BundleFileTrackingID(downloadToken, file.Seq), DownloadURLExpiry)
+	}
+	return proxyDownloadURL(downloadToken) + "/files/" + strconv.Itoa(file.Seq), nil
+}
+
+// BundleFileTrackingID 多文件分享中单个文件的跟踪标识：下载令牌.文件序号
+func BundleFileTrackingID(downloadToken string, seq int) string {
+	return downloadToken + "." + strconv.Itoa(seq)
+}
+
+// proxyDownloadURL 代理下载链接
+func proxyDownloadURL(token string) string {
+	return strings.TrimSuffix(conf.AppConfig.Download.PublicURL, "/") + DownloadPath + token
+}
+
+// ManifestEntry 取件清单中的一个文件
+type ManifestEntry struct {
+	Seq         int    `json:"seq"`
+	FileName    string `json:"fileName"`
+	Path        string `json:"path"` // 文件在分享中的相对路径
+	FileSize    int64  `json:"fileSize"`
+	MimeType    string `json:"mimeType"`
+	Category    string `json:"category"`
+	Sha256      string `json:"sha256"`
+	DownloadURL string `json:"downloadUrl,omitempty"`
+}
+
+// NewManifest 根据取件结果生成文件清单（单文件分享的清单只有一项），各文件的 StorageUrl 应已替换为下载链接
+func NewManifest(transInfo *model.TransInfo) []ManifestEntry {
+	if !transInfo.IsBundle() {
+		return []ManifestEntry{{
+			FileName:    transInfo.FileName,
+			Path:        transInfo.FileName,
+			FileSize:    transInfo.FileSize,
+			MimeType:    transInfo.MimeType,
+			Category:    transInfo.Category,
+			Sha256:      transInfo.Sha256,
+			DownloadURL: transInfo.StorageUrl,
+		}}
+	}
+	manifest := make([]ManifestEntry, 0, len(transInfo.Files))
+	for _, file := range transInfo.Files {
+		manifest = append(manifest, ManifestEntry{
+			Seq:         file.Seq,
+			FileName:    file.FileName,
+			Path:        file.EntryPath(),
+			FileSize:    file.FileSize,
+			MimeType:    file.MimeType,
+			Category:    file.Category,
+			Sha256:      file.Sha256,
+			DownloadURL: file.StorageUrl,
+		})
+	}
+	return manifest
+}
+
+// NewFileList 生成不含下载链接的文件清单，用于发送结果
+func NewFileList(transInfo *model.TransInfo) []ManifestEntry {
+	manifest := NewManifest(transInfo)
+	for i := range manifest {
+		manifest[i].DownloadURL = ""
+	}
+	return manifest
+}
+
+// DownloadService 代理下载及服务端判定下载完成
+type DownloadService struct {
+	transInfoDB          *repository.TransInfoDAO
+	shareFileDB          *repository.ShareFileDAO
+	downloadEventService *DownloadEventService
+	reaper               *ReaperService
+	rClient              *redis.Client
+}
+
+// NewDownloadService 创建一个新的 DownloadService 实例
+func NewDownloadService() *DownloadService {
+	return &DownloadService{
+		transInfoDB:          repository.NewTransInfoDAO(),
+		shareFileDB:          repository.NewShareFileDAO(),
+		downloadEventService: NewDownloadEventService(),
+		reaper:               NewReaperService(),
+		rClient:              database.RClient,
+	}
+}
+
+// Resolve 校验下载令牌，返回对应的分享（加密存储的分享同时取回数据密钥）
+func (d *DownloadService) Resolve(ctx context.Context, token string) (*model.TransInfo, error) {
+	fileUUID, err := d.rClient.Get(ctx, downloadTokenKeyPrefix+token).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrDownloadLinkInvalid
+		}
+		return nil, err
+	}
+
+	transInfo, err := d.transInfoDB.GetByUUID(fileUUID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDownloadLinkInvalid
+		}
+		return nil, err
+	}
+	if transInfo.IsRevoked {
+		return nil, ErrShareRevoked
+	}
+	if transInfo.IsEncrypted() {
+		if transInfo.DataKey, err = loadDownloadKey(ctx, d.rClient, token); err != nil {
+			return nil, err
+		}
+	}
+	return transInfo, nil
+}
+
+// Open 打开存储对象，dataKey 不为空时边读取边解密（大小为明文大小），调用方负责关闭返回的对象
+func (d *DownloadService) Open(ctx context.Context, storageURL string, dataKey []byte) (io.ReadSeekCloser, storage.ObjectInfo, error) {
+	object, info, err := storage.Default.Get(ctx, storage.Default.ObjectName(storageURL))
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			return nil, storage.ObjectInfo{}, ErrDownloadLinkInvalid
+		}
+		return nil, storage.ObjectInfo{}, err
+	}
+	if dataKey == nil {
+		return object, info, nil
+	}
+
+	decrypted, info, err := decryptObject(object, info, dataKey)
+	if err != nil {
+		object.Close()
+		return nil, storage.ObjectInfo{}, err
+	}
+	return decrypted, info, nil
+}
+
+// BundleFile 查询多文件分享中指定序号的文件，不存在时返回 ErrDownloadLinkInvalid
+func (d *DownloadService) BundleFile(transInfo *model.TransInfo, seq int) (*model.ShareFile, error) {
+	if !transInfo.IsBundle() {
+		return nil, ErrDownloadLinkInvalid
+	}
+	file, err := d.shareFileDB.GetBySeq(transInfo.FileUuid, seq)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDownloadLinkInvalid
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+// WriteBundle 将多文件分享的全部文件边读取边打包为zip写入w
+func (d *DownloadService) WriteBundle(ctx context.Context, transInfo *model.TransInfo, w io.Writer) error {
+	files, err := d.shareFileDB.ListByFileUUID(transInfo.FileUuid)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]utils.ZipEntry, 0, len(files))
+	for _, file := range files {
+		entries = append(entries, utils.ZipEntry{
+			Name: file.EntryPath(),
+			Open: func() (io.ReadCloser, error) {
+				object, _, err := d.Open(ctx, file.StorageUrl, transInfo.DataKey)
+				return object, err
+			},
+		})
+	}
+	return utils.CompressFiles(w, entries)
+}
+
+// RecordServed 累加下载链接已传输的字节数（断点续传、分段下载的多次请求合并统计），size 为本次下载的文件大小；
+// 首次传输完整个分享时标记取件完成，trackingID 为 BundleFileTrackingID 时统计多文件分享中的单个文件
+func (d *DownloadService) RecordServed(ctx context.Context, trackingID string, size, served int64, clientIP, userAgent string) {
+	if trackingID == "" {
+		return
+	}
+	token, part, isFile := strings.Cut(trackingID, ".")
+	if !isFile {
+		part = trackAllPart
+	}
+	result, err := trackScript.Run(ctx, d.rClient, []string{downloadTrackKeyPrefix + token}, served, size, part).Slice()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			logger.Error("统计下载传输字节数失败", "err", err)
+		}
+		return
+	}
+	fileUUID, _ := result[0].(string)
+	total, _ := result[1].(int64)
+	d.complete(fileUUID, total, clientIP, userAgent)
+}
+
+// RecordStorageAccess 处理存储后端审计日志中附带跟踪标识的下载请求（MinIO预签名直链下载不经过后端），
+// 按实际传输的字节数累加，与 RecordServed 相同地合并同一链接的多次请求
+func (d *DownloadService) RecordStorageAccess(ctx context.Context, trackingID string, served int64, clientIP, userAgent string) {
+	token, seq, isFile := strings.Cut(trackingID, ".")
+	values, err := d.rClient.HMGet(ctx, downloadTrackKeyPrefix+token, "file", "size").Result()
+	if err != nil {
+		logger.Error("查询下载传输统计失败", "err", err)
+		return
+	}
+	fileUUID, _ := values[0].(string)
+	sizeStr, _ := values[1].(string)
+	if fileUUID == "" {
+		return
+	}
+
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if isFile {
+		// 多文件分享中的单个文件，以该文件的大小判断是否传输完整
+		var seqNum int
+		if seqNum, err = strconv.Atoi(seq); err == nil {
+			var file *model.ShareFile
+			if file, err = d.shareFileDB.GetBySeq(fileUUID, seqNum); err == nil {
+				size = file.FileSize
+			}
+		}
+	}
+	if err != nil {
+		logger.Error("查询下载文件大小失败", "err", err, "fileUuid", fileUUID, "trackingId", trackingID)
+		return
+	}
+	d.RecordServed(ctx, trackingID, size, served, clientIP, userAgent)
+}
+
+// complete 标记取件完成并记录下载完成事件
+func (d *DownloadService) complete(fileUUID string, bytesServed int64, clientIP, userAgent string) {
+	if err := d.transInfoDB.UpdateReceiveInfo(fileUUID); err != nil {
+		logger.Error("更新取件状态失败", "err", err, "fileUuid", fileUUID)
+	}
+	d.downloadEventService.Record(&model.DownloadEvent{
+		FileUuid:    fileUUID,
+		ClientIP:    clientIP,
+		UserAgent:   userAgent,
+		BytesServed: bytesServed,
+		Outcome:     model.OutcomeCompleted,
+	})
+	logger.Info("文件下载完成", "fileUuid", fileUUID, "bytes", bytesServed)
+	d.burnIfExhausted(fileUUID)
+}
+
+// burnIfExhausted 限次数分享的下载次数已用尽、且已发出的下载链接都下载完成时，直接删除分享及存储对象，
+// 不依赖过期清理任务（阅后即焚）
+func (d *DownloadService) burnIfExhausted(fileUUID string) {
+	transInfo, err := d.transInfoDB.GetByUUID(fileUUID)
+	if err != nil {
+		logger.Error("查询分享失败", "err", err, "fileUuid", fileUUID)
+		return
+	}
+	if transInfo.MaxDownloads == 0 {
+		return
+	}
+
+	pending, err := pendingDoneScript.Run(context.Background(), d.rClient, []string{downloadPendingKeyPrefix + fileUUID}).Int64()
+	if err != nil {
+		logger.Error("更新未完成的下载链接数失败", "err", err, "fileUuid", fileUUID)
+		return
+	}
+	if pending > 0 || transInfo.DownloadCount < transInfo.MaxDownloads {
+		return
+	}
+	if d.reaper.Reap(transInfo) {
+		logger.Info("下载次数已用尽，已删除分享", "fileUuid", fileUUID, "maxDownloads", transInfo.MaxDownloads)
+	}
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"daoke.com/file_trans/conf"
+	"daoke.com/file_trans/database"
+	"daoke.com/file_trans/model"
+	"daoke.com/file_trans/storage"
+	"daoke.com/file_trans/utils"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"io"
+)
+
+// downloadKeyKeyPrefix 代理下载令牌对应的数据密钥在Redis中的键前缀（值经服务端密钥与令牌派生的密钥加密）
+const downloadKeyKeyPrefix = "dl_key:"
+
+// 密钥派生用途
+const (
+	shareKeyPurpose    = "file_trans/share-key"    // 包装分享的数据密钥
+	downloadKeyPurpose = "file_trans/download-key" // 加密代理下载令牌对应的数据密钥
+)
+
+// EncryptionEnabled 是否加密存储表单发送的文件和文本
+func EncryptionEnabled() bool {
+	return conf.AppConfig.Encryption.Enabled
+}
+
+// NewShareKey 为分享生成随机数据密钥，返回数据密钥及以取件码和服务端密钥派生的密钥包装后的数据密钥；
+// 存储对象、数据库均不保存明文数据密钥，仅凭存储后端的访问权限无法解密
+func NewShareKey(fileUUID, pickupCode string) ([]byte, string, error) {
+	dataKey, err := utils.GenerateDataKey()
+	if err != nil {
+		return nil, "", err
+	}
+	wrappedKey, err := utils.SealKey(shareKEK(fileUUID, pickupCode), dataKey, fileUUID)
+	if err != nil {
+		return nil, "", err
+	}
+	return dataKey, wrappedKey, nil
+}
+
+// UnwrapShareKey 使用取件码解包加密分享的数据密钥，写入 transInfo.DataKey
+func UnwrapShareKey(transInfo *model.TransInfo, pickupCode string) error {
+	dataKey, err := utils.OpenKey(shareKEK(transInfo.FileUuid, pickupCode), transInfo.WrappedKey, transInfo.FileUuid)
+	if err != nil {
+		return err
+	}
+	transInfo.DataKey = dataKey
+	return nil
+}
+
+// shareKEK 派生包装数据密钥的密钥
+func shareKEK(fileUUID, pickupCode string) []byte {
+	return utils.DeriveKey(conf.AppConfig.Encryption.Secret, shareKeyPurpose, fileUUID, pickupCode)
+}
+
+// SealInlineText 使用分享的数据密钥加密内联保存的文本，返回base64编码的密文
+func SealInlineText(dataKey []byte, fileUUID, text string) (string, error) {
+	return utils.SealKey(dataKey, []byte(text), fileUUID)
+}
+
+// openInlineText 解密内联保存的文本
+func openInlineText(transInfo *model.TransInfo) (string, error) {
+	text, err := utils.OpenKey(transInfo.DataKey, transInfo.TextContent, transInfo.FileUuid)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+// saveDownloadKey 保存代理下载令牌对应的数据密钥，代理下载时不再需要取件码
+func saveDownloadKey(ctx context.Context, token string, dataKey []byte) error {
+	if dataKey == nil {
+		return ErrDataKeyUnavailable
+	}
+	sealed, err := utils.SealKey(downloadKEK(token), dataKey, token)
+	if err != nil {
+		return err
+	}
+	return database.RClient.Set(ctx, downloadKeyKeyPrefix+token, sealed, DownloadURLExpiry).Err()
+}
+
+// loadDownloadKey 读取代理下载令牌对应的数据密钥，令牌已失效时返回 ErrDownloadLinkInvalid
+func loadDownloadKey(ctx context.Context, rClient *redis.Client, token string) ([]byte, error) {
+	sealed, err := rClient.Get(ctx, downloadKeyKeyPrefix+token).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrDownloadLinkInvalid
+		}
+		return nil, err
+	}
+	return utils.OpenKey(downloadKEK(token), sealed, token)
+}
+
+// downloadKEK 派生加密代理下载令牌对应数据密钥的密钥
+func downloadKEK(token string) []byte {
+	return utils.DeriveKey(conf.AppConfig.Encryption.Secret, downloadKeyPurpose, token)
+}
+
+// decryptedObject 边读取边解密的存储对象
+type decryptedObject struct {
+	io.ReadSeeker
+	io.Closer
+}
+
+// decryptObject 将加密存储的对象包装为解密后的对象，info.Size 替换为明文大小
+func decryptObject(object io.ReadSeekCloser, info storage.ObjectInfo, dataKey []byte) (io.ReadSeekCloser, storage.ObjectInfo, error) {
+	reader, size, err := utils.NewDecryptReader(dataKey, object, info.Size)
+	if err != nil {
+		return nil, storage.ObjectInfo{}, err
+	}
+	info.Size = size
+	info.ContentType = ""
+	return &decryptedObject{ReadSeeker: reader, Closer: object}, info, nil
+}
@@ -0,0 +1,69 @@
+package service
+
+import "errors"
+
+var (
+	// ErrPickupCodeConflict 指定的取件码已被其他分享占用
+	ErrPickupCodeConflict = errors.New("pickupCode already in use")
+	// ErrPickupCodeInvalid 指定的取件码格式不符合配置
+	ErrPickupCodeInvalid = errors.New("pickupCode is invalid")
+	// ErrPickupCodeExhausted 多次重试后仍未能分配到空闲取件码
+	ErrPickupCodeExhausted = errors.New("no free pickupCode available")
+	// ErrPickupCodeNotFound 取件码不存在
+	ErrPickupCodeNotFound = errors.New("pickupCode not found")
+	// ErrPickupCodeExpired 取件码对应的分享已过期
+	ErrPickupCodeExpired = errors.New("pickupCode is expired")
+	// ErrPasswordRequired 分享受密码保护，未提供密码
+	ErrPasswordRequired = errors.New("password required")
+	// ErrPasswordIncorrect 分享密码错误
+	ErrPasswordIncorrect = errors.New("password incorrect")
+	// ErrPasswordLocked 密码错误次数过多，暂时锁定
+	ErrPasswordLocked = errors.New("password attempts exceeded")
+	// ErrManageTokenInvalid 分享不存在或管理令牌不匹配
+	ErrManageTokenInvalid = errors.New("manage token invalid")
+	// ErrShareRevoked 分享已被发送者撤销
+	ErrShareRevoked = errors.New("share revoked")
+	// ErrDownloadLinkInvalid 代理下载链接无效或已过期
+	ErrDownloadLinkInvalid = errors.New("download link invalid")
+	// ErrShareNotFound 分享或分享中的文件不存在
+	ErrShareNotFound = errors.New("share not found")
+	// ErrChecksumUnavailable 文件没有记录校验值
+	ErrChecksumUnavailable = errors.New("checksum unavailable")
+	// ErrDataKeyUnavailable 加密存储的分享缺少解包后的数据密钥
+	ErrDataKeyUnavailable = errors.New("data key unavailable")
+
+	// ErrUserExists 用户名已被注册
+	ErrUserExists = errors.New("user already exists")
+	// ErrInvalidCredentials 用户名或密码错误
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrTokenInvalid 令牌无效、已过期或已被注销
+	ErrTokenInvalid = errors.New("token invalid")
+	// ErrInvalidCursor 分页游标无效或与排序方式不匹配
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	// ErrStorageUnsupported 当前存储后端不支持该上传方式
+	ErrStorageUnsupported = errors.New("unsupported by storage backend")
+	// ErrUploadNotFound 分片上传会话不存在或已过期
+	ErrUploadNotFound = errors.New("upload session not found")
+	// ErrUploadInvalidParams 分片上传初始化参数无效
+	ErrUploadInvalidParams = errors.New("invalid upload params")
+	// ErrUploadTooManyParts 文件过大，分片数超过上限
+	ErrUploadTooManyParts = errors.New("too many upload parts")
+	// ErrUploadInvalidPart 分片序号或大小不合法
+	ErrUploadInvalidPart = errors.New("invalid upload part")
+	// ErrUploadIncomplete 仍有分片未上传
+	ErrUploadIncomplete = errors.New("upload is incomplete")
+	// ErrUploadMismatch 已上传对象的大小或类型与声明不符
+	ErrUploadMismatch = errors.New("uploaded object does not match")
+
+	// ErrFileTooLarge 单个文件超过大小上限
+	ErrFileTooLarge = errors.New("file too large")
+	// ErrTextTooLarge 文本内容超过大小上限
+	ErrTextTooLarge = errors.New("text too large")
+	// ErrTooManyFiles 单次发送的文件数超过上限
+	ErrTooManyFiles = errors.New("too many files")
+	// ErrFileTypeNotAllowed 文件扩展名或MIME类型不在允许范围内
+	ErrFileTypeNotAllowed = errors.New("file type not allowed")
+	// ErrEncryptedMetaInvalid 端到端加密分享的加密元数据为空、过长或不是base64编码
+	ErrEncryptedMetaInvalid = errors.New("encrypted meta invalid")
+)
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"daoke.com/file_trans/conf"
+	"daoke.com/file_trans/database"
+	"daoke.com/file_trans/logger"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// 取件防爆破相关的Redis键前缀
+const (
+	guardFailKeyPrefix  = "guard:fail:"  // 统计窗口内的失败次数
+	guardLockKeyPrefix  = "guard:lock:"  // 锁定标记，TTL即剩余锁定时长
+	guardLevelKeyPrefix = "guard:level:" // 锁定级别，用于递增锁定时长
+	guardCodesKeyPrefix = "guard:codes:" // 统计窗口内尝试过的不同取件码（HyperLogLog）
+	guardAlertKeyPrefix = "guard:alert:" // 枚举告警标记，避免重复告警
+	guardGlobalKey      = "global"       // 全局统计使用的标识
+)
+
+// GuardService 取件码防爆破：按客户端IP和全局统计失败次数，超限后逐级延长锁定时长
+type GuardService struct {
+	rClient *redis.Client
+}
+
+// NewGuardService 创建一个新的 GuardService 实例
+func NewGuardService() *GuardService {
+	return &GuardService{
+		rClient: database.RClient,
+	}
+}
+
+// CheckLocked 检查客户端IP或全局是否处于锁定状态，返回剩余锁定时长（0表示未锁定）
+func (g *GuardService) CheckLocked(clientIP string) (time.Duration, error) {
+	ctx := context.Background()
+	for _, subject := range []string{clientIP, guardGlobalKey} {
+		ttl, err := g.rClient.PTTL(ctx, guardLockKeyPrefix+subject).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return 0, err
+		}
+		if ttl > 0 {
+			return ttl, nil
+		}
+	}
+	return 0, nil
+}
+
+// RecordFailure 记录一次取件失败，达到阈值时锁定并返回锁定时长（0表示未触发锁定）
+func (g *GuardService) RecordFailure(clientIP, pickupCode string) (time.Duration, error) {
+	ctx := context.Background()
+	securityConf := conf.AppConfig.Security
+
+	// 单个IP的统计窗口随每次失败顺延，持续试探的客户端计数不会被清零；
+	// 全局计数按固定窗口分桶，避免正常用户的零星输错长期累积
+	globalFailKey := fmt.Sprintf("%s%s:%d", guardFailKeyPrefix, guardGlobalKey,
+		time.Now().UnixMilli()/max(securityConf.RedeemWindow.Milliseconds(), 1))
+	pipe := g.rClient.TxPipeline()
+	ipFails := pipe.Incr(ctx, guardFailKeyPrefix+clientIP)
+	pipe.Expire(ctx, guardFailKeyPrefix+clientIP, securityConf.RedeemWindow)
+	globalFails := pipe.Incr(ctx, globalFailKey)
+	pipe.Expire(ctx, globalFailKey, securityConf.RedeemWindow)
+	pipe.PFAdd(ctx, guardCodesKeyPrefix+clientIP, pickupCode)
+	pipe.Expire(ctx, guardCodesKeyPrefix+clientIP, securityConf.RedeemWindow)
+	distinctCodes := pipe.PFCount(ctx, guardCodesKeyPrefix+clientIP)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	g.alertEnumeration(ctx, clientIP, distinctCodes.Val())
+
+	// 全局失败次数超限：短暂暂停所有取件
+	if globalFails.Val() >= int64(securityConf.GlobalMaxFailures) {
+		if ok, _ := g.rClient.SetNX(ctx, guardLockKeyPrefix+guardGlobalKey, 1, securityConf.GlobalLockout).Result(); ok {
+			g.rClient.Del(ctx, globalFailKey)
+			logger.Error("全局取件失败次数超限，疑似分布式枚举取件码，暂停取件",
+				"failures", globalFails.Val(), "lockout", securityConf.GlobalLockout)
+		}
+	}
+
+	// 单个IP失败次数超限：按锁定级别递增锁定时长
+	if ipFails.Val() < int64(securityConf.RedeemMaxFailures) {
+		return 0, nil
+	}
+	level, err := g.rClient.Incr(ctx, guardLevelKeyPrefix+clientIP).Result()
+	if err != nil {
+		return 0, err
+	}
+	g.rClient.Expire(ctx, guardLevelKeyPrefix+clientIP, securityConf.RedeemMaxLockout)
+
+	lockout := lockoutDuration(level, securityConf.RedeemLockout, securityConf.RedeemMaxLockout)
+	pipe = g.rClient.TxPipeline()
+	pipe.Set(ctx, guardLockKeyPrefix+clientIP, level, lockout)
+	pipe.Del(ctx, guardFailKeyPrefix+clientIP)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	logger.Warn("取件失败次数过多，锁定客户端", "client_ip", clientIP, "level", level, "lockout", lockout)
+	return lockout, nil
+}
+
+// alertEnumeration 单个IP在统计窗口内尝试的不同取件码过多时输出告警日志
+func (g *GuardService) alertEnumeration(ctx context.Context, clientIP string, distinctCodes int64) {
+	securityConf := conf.AppConfig.Security
+	if distinctCodes < int64(securityConf.EnumerationAlertAt) {
+		return
+	}
+	if ok, _ := g.rClient.SetNX(ctx, guardAlertKeyPrefix+clientIP, 1, securityConf.RedeemWindow).Result(); ok {
+		logger.Error("疑似枚举取件码", "client_ip", clientIP, "distinct_codes", distinctCodes,
+			"window", securityConf.RedeemWindow)
+	}
+}
+
+// lockoutDuration 计算第 level 次锁定的时长：base * 2^(level-1)，不超过 max
+func lockoutDuration(level int64, base, max time.Duration) time.Duration {
+	lockout := base
+	for i := int64(1); i < level && lockout < max; i++ {
+		lockout *= 2
+	}
+	if lockout > max {
+		lockout = max
+	}
+	return lockout
+}
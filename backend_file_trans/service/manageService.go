@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"daoke.com/file_trans/database"
+	"daoke.com/file_trans/logger"
+	"daoke.com/file_trans/model"
+	"daoke.com/file_trans/repository"
+	"daoke.com/file_trans/utils"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"time"
+)
+
+// revokeScript 撤销分享（KEYS[1]为取件码，KEYS[2]为剩余下载次数，KEYS[3]为取件码反向索引，ARGV[1]为fileUuid）：
+// 取件码仍指向该文件时才删除，避免误删已被其他分享重新占用的取件码
+var revokeScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("DEL", KEYS[1])
+end
+redis.call("DEL", KEYS[2], KEYS[3])
+return 1
+`)
+
+// extendScript 调整分享有效期（KEYS同上，ARGV[2]为新的有效期毫秒数）：
+// 取件码已失效（过期、次数用尽或被撤销）时返回0，否则同时刷新三个键的过期时间
+var extendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+redis.call("PEXPIRE", KEYS[2], ARGV[2])
+redis.call("PEXPIRE", KEYS[3], ARGV[2])
+return 1
+`)
+
+// ShareStats 分享的管理视图：基本信息及下载统计
+type ShareStats struct {
+	FileUuid           string           `json:"fileUuid"`
+	FileName           string           `json:"fileName"`
+	FileSize           int64            `json:"fileSize"`
+	FileCount          int              `json:"fileCount"`
+	FileType           string           `json:"fileType"`
+	CreatedAt          time.Time        `json:"createdAt"`
+	ExpireAt           time.Time        `json:"expireAt"`
+	Expired            bool             `json:"expired"`
+	Revoked            bool             `json:"revoked"`
+	HasPassword        bool             `json:"hasPassword"`
+	MaxDownloads       int              `json:"maxDownloads"`
+	DownloadCount      int              `json:"downloadCount"`
+	RemainingDownloads int64            `json:"remainingDownloads"` // -1 表示不限次数或分享已失效
+	Outcomes           map[string]int64 `json:"outcomes"`
+}
+
+// ManageService 发送者凭管理令牌撤销分享、调整有效期及查看下载统计，无需账号
+type ManageService struct {
+	transInfoDB     *repository.TransInfoDAO
+	reapRecordDB    *repository.ReapRecordDAO
+	shareFileDB     *repository.ShareFileDAO
+	blobService     *BlobService
+	downloadEventDB *repository.DownloadEventDAO
+	rClient         *redis.Client
+}
+
+// NewManageService 创建一个新的 ManageService 实例
+func NewManageService() *ManageService {
+	return &ManageService{
+		transInfoDB:     repository.NewTransInfoDAO(),
+		reapRecordDB:    repository.NewReapRecordDAO(),
+		shareFileDB:     repository.NewShareFileDAO(),
+		blobService:     NewBlobService(),
+		downloadEventDB: repository.NewDownloadEventDAO(),
+		rClient:         database.RClient,
+	}
+}
+
+// Authorize 校验管理令牌（登录用户管理自己的分享时无需令牌），
+// 分享不存在与令牌不匹配统一返回 ErrManageTokenInvalid
+func (m *ManageService) Authorize(fileUUID, token string, userID uint) (*model.TransInfo, error) {
+	transInfo, err := m.transInfoDB.GetByUUID(fileUUID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrManageTokenInvalid
+		}
+		return nil, err
+	}
+	if userID != 0 && transInfo.UserID == userID {
+		return transInfo, nil
+	}
+	if !utils.CheckToken(transInfo.ManageToken, token) {
+		return nil, ErrManageTokenInvalid
+	}
+	return transInfo, nil
+}
+
+// Stats 查询分享的基本信息、剩余下载次数及按结果汇总的下载事件数
+func (m *ManageService) Stats(transInfo *model.TransInfo) (*ShareStats, error) {
+	ctx := context.Background()
+	stats := &ShareStats{
+		FileUuid:           transInfo.FileUuid,
+		FileName:           transInfo.FileName,
+		FileSize:           transInfo.FileSize,
+		FileCount:          transInfo.FileCount,
+		FileType:           transInfo.FileType,
+		CreatedAt:          transInfo.CreatedAt,
+		ExpireAt:           transInfo.ExpireAt,
+		Expired:            transInfo.IsExpire || (!transInfo.ExpireAt.IsZero() && time.Now().After(transInfo.ExpireAt)),
+		Revoked:            transInfo.IsRevoked,
+		HasPassword:        transInfo.PasswordHash != "",
+		MaxDownloads:       transInfo.MaxDownloads,
+		DownloadCount:      transInfo.DownloadCount,
+		RemainingDownloads: -1,
+	}
+
+	if transInfo.MaxDownloads > 0 && !stats.Expired {
+		remaining, err := m.rClient.Get(ctx, DownloadLimitKey(transInfo.FileUuid)).Int64()
+		switch {
+		case err == nil:
+			stats.RemainingDownloads = remaining
+		case errors.Is(err, redis.Nil):
+			stats.RemainingDownloads = 0
+		default:
+			return nil, err
+		}
+	}
+
+	outcomes, err := m.downloadEventDB.CountByOutcome(transInfo.FileUuid)
+	if err != nil {
+		return nil, err
+	}
+	stats.Outcomes = outcomes
+	return stats, nil
+}
+
+// Revoke 撤销分享：删除取件码使其立即失效，标记记录为已撤销，并删除存储对象
+func (m *ManageService) Revoke(transInfo *model.TransInfo) error {
+	ctx := context.Background()
+	fileUUID := transInfo.FileUuid
+
+	// 先让取件码失效，阻止新的取件
+	pickupCode, err := m.rClient.Get(ctx, PickupCodeKey(fileUUID)).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	keys := []string{pickupCode, DownloadLimitKey(fileUUID), PickupCodeKey(fileUUID)}
+	if err := revokeScript.Run(ctx, m.rClient, keys, fileUUID).Err(); err != nil {
+		return err
+	}
+
+	// 以条件更新抢占清理：重复撤销，或过期清理任务已释放存储对象时无需再释放
+	claimed, err := m.transInfoDB.MarkRevoked(transInfo.ID)
+	if err != nil || !claimed {
+		return err
+	}
+
+	for _, record := range deleteShareObjects(ctx, transInfo, m.shareFileDB, m.blobService) {
+		if !record.Success {
+			logger.Error("删除已撤销分享的存储对象失败", "err", record.ErrMsg, "fileUuid", fileUUID, "storageUrl", record.StorageUrl)
+		}
+		if err := m.reapRecordDB.Create(&record); err != nil {
+			logger.Error("保存撤销清理记录失败", "err", err, "fileUuid", fileUUID)
+		}
+	}
+	return nil
+}
+
+// UpdateExpiry 将分享的有效期调整为从现在起的 expiry（可延长也可缩短），返回新的过期时间
+func (m *ManageService) UpdateExpiry(transInfo *model.TransInfo, expiry time.Duration) (time.Time, error) {
+	if transInfo.IsRevoked {
+		return time.Time{}, ErrShareRevoked
+	}
+	if transInfo.IsExpire {
+		return time.Time{}, ErrPickupCodeExpired
+	}
+
+	ctx := context.Background()
+	fileUUID := transInfo.FileUuid
+	pickupCode, err := m.rClient.Get(ctx, PickupCodeKey(fileUUID)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return time.Time{}, ErrPickupCodeExpired
+		}
+		return time.Time{}, err
+	}
+
+	keys := []string{pickupCode, DownloadLimitKey(fileUUID), PickupCodeKey(fileUUID)}
+	ok, err := extendScript.Run(ctx, m.rClient, keys, fileUUID, expiry.Milliseconds()).Int()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if ok == 0 {
+		return time.Time{}, ErrPickupCodeExpired
+	}
+
+	expireAt := time.Now().Add(expiry)
+	if err := m.transInfoDB.UpdateExpireAt(fileUUID, expireAt); err != nil {
+		return time.Time{}, err
+	}
+	return expireAt, nil
+}
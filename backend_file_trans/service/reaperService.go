@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"daoke.com/file_trans/conf"
+	"daoke.com/file_trans/database"
+	"daoke.com/file_trans/logger"
+	"daoke.com/file_trans/model"
+	"daoke.com/file_trans/repository"
+	"daoke.com/file_trans/storage"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+// reaperLockKey 过期清理分布式锁的Redis键
+const reaperLockKey = "reaper:lock"
+
+// releaseLockScript 仅当锁仍由当前实例持有时才删除
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+type ReaperService struct {
+	transInfoDB  *repository.TransInfoDAO
+	reapRecordDB *repository.ReapRecordDAO
+	shareFileDB  *repository.ShareFileDAO
+	blobService  *BlobService
+	rClient      *redis.Client
+	instanceID   string // 当前实例标识，用于分布式锁
+}
+
+// NewReaperService 创建一个新的 ReaperService 实例
+func NewReaperService() *ReaperService {
+	return &ReaperService{
+		transInfoDB:  repository.NewTransInfoDAO(),
+		reapRecordDB: repository.NewReapRecordDAO(),
+		shareFileDB:  repository.NewShareFileDAO(),
+		blobService:  NewBlobService(),
+		rClient:      database.RClient,
+		instanceID:   uuid.New().String(),
+	}
+}
+
+// Start 按配置的间隔周期性执行过期清理，直到ctx被取消
+func (r *ReaperService) Start(ctx context.Context) {
+	reaperConf := conf.AppConfig.Reaper
+	if !reaperConf.Enabled {
+		logger.Info("过期清理任务未启用")
+		return
+	}
+
+	logger.Info("过期清理任务已启动", "interval", reaperConf.Interval, "instance", r.instanceID)
+	ticker := time.NewTicker(reaperConf.Interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				logger.Info("过期清理任务已停止")
+				return
+			case <-ticker.C:
+				r.RunOnce(ctx)
+			}
+		}
+	}()
+}
+
+// RunOnce 执行一轮过期清理：抢占分布式锁后，标记过期记录并删除对应的存储对象
+func (r *ReaperService) RunOnce(ctx context.Context) {
+	reaperConf := conf.AppConfig.Reaper
+
+	// 1. 抢占分布式锁，未抢到说明其他实例正在清理
+	locked, err := r.rClient.SetNX(ctx, reaperLockKey, r.instanceID, reaperConf.LockTTL).Result()
+	if err != nil {
+		logger.Error("获取过期清理锁失败", "err", err)
+		return
+	}
+	if !locked {
+		logger.Debug("其他实例正在执行过期清理，跳过本轮")
+		return
+	}
+	defer releaseLockScript.Run(context.Background(), r.rClient, []string{reaperLockKey}, r.instanceID)
+
+	// 2. 清理超过会话有效期仍未完成的分片上传，以及直传凭证失效后遗留在原路径的对象
+	r.abortStaleUploads(ctx)
+	r.deleteStalePresignedObjects(ctx)
+
+	// 3. 查询已到期的记录
+	expired, err := r.transInfoDB.FindExpired(time.Now(), reaperConf.BatchSize)
+	if err != nil {
+		logger.Error("查询过期记录失败", "err", err)
+		return
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	reaped := 0
+	for i := range expired {
+		if r.Reap(&expired[i]) {
+			reaped++
+		}
+	}
+	logger.Info("过期清理完成", "found", len(expired), "reaped", reaped)
+}
+
+// Reap 清理单条过期记录（下载次数用尽时也由取件、下载流程直接调用），返回是否由本次调用完成清理
+func (r *ReaperService) Reap(transInfo *model.TransInfo) bool {
+	// 条件更新保证即使锁失效，同一条记录也只会被一个实例清理
+	marked, err := r.transInfoDB.MarkExpired(transInfo.ID)
+	if err != nil {
+		logger.Error("标记记录过期失败", "err", err, "fileUuid", transInfo.FileUuid)
+		return false
+	}
+	if !marked {
+		return false
+	}
+
+	for _, record := range deleteShareObjects(context.Background(), transInfo, r.shareFileDB, r.blobService) {
+		if !record.Success {
+			logger.Error("删除过期存储对象失败", "err", record.ErrMsg, "fileUuid", transInfo.FileUuid, "storageUrl", record.StorageUrl)
+		}
+		if err := r.reapRecordDB.Create(&record); err != nil {
+			logger.Error("保存过期清理记录失败", "err", err, "fileUuid", transInfo.FileUuid)
+		}
+	}
+	return true
+}
+
+// deleteShareObjects 释放分享引用的全部存储对象（多文件分享逐个释放），没有其他分享引用的对象会被删除，
+// 返回每个对象的清理记录
+func deleteShareObjects(ctx context.Context, transInfo *model.TransInfo, shareFileDB *repository.ShareFileDAO, blobService *BlobService) []model.ReapRecord {
+	newRecord := func(storageURL string, err error) model.ReapRecord {
+		record := model.ReapRecord{
+			FileUuid:   transInfo.FileUuid,
+			FileName:   transInfo.FileName,
+			StorageUrl: storageURL,
+			Success:    err == nil,
+			ReapedAt:   time.Now(),
+		}
+		if err != nil {
+			record.ErrMsg = err.Error()
+		}
+		return record
+	}
+
+	if !transInfo.IsBundle() {
+		return []model.ReapRecord{newRecord(transInfo.StorageUrl, blobService.Release(ctx, transInfo.StorageUrl))}
+	}
+
+	files, err := shareFileDB.ListByFileUUID(transInfo.FileUuid)
+	if err != nil {
+		return []model.ReapRecord{newRecord("", err)}
+	}
+	records := make([]model.ReapRecord, 0, len(files))
+	for _, file := range files {
+		record := newRecord(file.StorageUrl, blobService.Release(ctx, file.StorageUrl))
+		record.FileName = file.FileName
+		records = append(records, record)
+	}
+	return records
+}
+
+// deleteStalePresignedObjects 删除直传凭证已失效（上传策略过期且超过确认期限）的原路径上的对象：
+// 包括未确认的上传，以及确认后又按策略写入原路径的内容（分享引用的是确认时复制的副本）
+func (r *ReaperService) deleteStalePresignedObjects(ctx context.Context) {
+	minioStore, ok := storage.Default.(*storage.MinIOStore)
+	if !ok {
+		return
+	}
+	objects, err := r.rClient.ZRangeByScore(ctx, presignObjectsKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(time.Now().Unix(), 10),
+		Count: int64(conf.AppConfig.Reaper.BatchSize),
+	}).Result()
+	if err != nil {
+		logger.Error("查询失效的直传凭证失败", "err", err)
+		return
+	}
+	for _, objectName := range objects {
+		if err := minioStore.Delete(ctx, objectName); err != nil {
+			logger.Error("删除失效直传凭证的对象失败", "err", err, "object", objectName)
+			continue
+		}
+		r.rClient.ZRem(ctx, presignObjectsKey, objectName)
+	}
+	if len(objects) > 0 {
+		logger.Info("已清理失效直传凭证的对象", "count", len(objects))
+	}
+}
+
+// abortStaleUploads 取消超过会话有效期仍未完成的分片上传，释放已上传分片占用的空间（仅MinIO存储）
+func (r *ReaperService) abortStaleUploads(ctx context.Context) {
+	minioStore, ok := storage.Default.(*storage.MinIOStore)
+	if !ok {
+		return
+	}
+	bucketName := minioStore.BucketName()
+	deadline := time.Now().Add(-conf.AppConfig.Upload.SessionTTL)
+	core := minio.Core{Client: minioStore.Client()}
+
+	for upload := range minioStore.Client().ListIncompleteUploads(ctx, bucketName, "", true) {
+		if upload.Err != nil {
+			logger.Error("查询未完成的分片上传失败", "err", upload.Err)
+			return
+		}
+		if upload.Initiated.After(deadline) {
+			continue
+		}
+		if err := core.AbortMultipartUpload(ctx, bucketName, upload.Key, upload.UploadID); err != nil {
+			logger.Error("取消过期分片上传失败", "err", err, "object", upload.Key)
+			continue
+		}
+		logger.Info("已取消过期分片上传", "object", upload.Key, "initiated", upload.Initiated)
+	}
+}
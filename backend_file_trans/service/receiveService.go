@@ -2,35 +2,78 @@ package service
 
 import (
 	"context"
+	"daoke.com/file_trans/conf"
 	"daoke.com/file_trans/database"
+	"daoke.com/file_trans/logger"
 	"daoke.com/file_trans/model"
 	"daoke.com/file_trans/repository"
 	"daoke.com/file_trans/utils"
 	"errors"
 	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"strings"
 	"time"
 )
 
+// passwordAttemptsKeyPrefix 分享密码错误次数在Redis中的键前缀
+const passwordAttemptsKeyPrefix = "pwd_attempts:"
+
+// passwordAttemptScript 先计入一次密码尝试再校验（KEYS[1]为错误次数键，ARGV[1]为最大次数，ARGV[2]为锁定时长毫秒），
+// 返回计入后的次数；未超过上限时刷新锁定时长，超过上限后的尝试不再延长锁定。并发请求也无法超出上限多做bcrypt校验
+var passwordAttemptScript = redis.NewScript(`
+local attempts = redis.call("INCR", KEYS[1])
+if attempts <= tonumber(ARGV[1]) then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return attempts
+`)
+
+// redeemScript 原子地兑换取件码（KEYS[1]为取件码，KEYS[2]为剩余下载次数，ARGV[1]为fileUuid）：
+// 取件码不存在或已指向其他文件返回空；不限次数返回-1；
+// 限次数时扣减剩余次数并返回剩余次数，次数用尽时同时删除取件码，保证并发下不会超额下载
+var redeemScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return false
+end
+if redis.call("EXISTS", KEYS[2]) == 0 then
+	return -1
+end
+local remaining = redis.call("DECR", KEYS[2])
+if remaining < 0 then
+	redis.call("DEL", KEYS[1], KEYS[2])
+	return false
+end
+if remaining == 0 then
+	redis.call("DEL", KEYS[1], KEYS[2])
+end
+return remaining
+`)
+
 type ReceiveService struct {
 	transInfoDB *repository.TransInfoDAO
+	shareFileDB *repository.ShareFileDAO
 	rClient     *redis.Client // 补充Redis依赖（之前缺失）
+	reaper      *ReaperService
 }
 
 func NewReceiveService() *ReceiveService {
 	return &ReceiveService{
 		transInfoDB: repository.NewTransInfoDAO(),
+		shareFileDB: repository.NewShareFileDAO(),
 		rClient:     database.RClient, // 从全局获取Redis客户端
+		reaper:      NewReaperService(),
 	}
 }
 
-// GetTransInfoByPickupCode 根据取件码查询并更新取件信息
-func (r *ReceiveService) GetTransInfoByPickupCode(pickupCode string) (*model.TransInfo, error) {
+// GetTransInfoByPickupCode 根据取件码查询并更新取件信息，受密码保护的分享需提供正确密码
+// 分享存在但被拒绝（已过期、密码错误等）时，仍返回分享信息以便记录下载事件
+func (r *ReceiveService) GetTransInfoByPickupCode(pickupCode, password string) (*model.TransInfo, error) {
 	// 1. 从Redis获取fileUuid（取件码映射关系）
 	ctx := context.Background()
 	fileUuid, err := r.rClient.Get(ctx, pickupCode).Result()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			return nil, errors.New("pickupCode not found")
+			return nil, ErrPickupCodeNotFound
 		}
 		return nil, err
 	}
@@ -41,39 +84,188 @@ func (r *ReceiveService) GetTransInfoByPickupCode(pickupCode string) (*model.Tra
 		return nil, err
 	}
 
-	// 3. 检查是否过期
-	if transInfo.IsExpire {
-		return nil, errors.New("取件码已过期！")
+	// 3. 检查是否过期（过期清理任务可能尚未执行，需同时比较过期时间）
+	if transInfo.IsExpire || (!transInfo.ExpireAt.IsZero() && time.Now().After(transInfo.ExpireAt)) {
+		return transInfo, ErrPickupCodeExpired
 	}
 
-	// 4. 生成预签名下载链接
-	bucketName, objectName := utils.ParseStorageURL(transInfo.StorageUrl)
+	// 4. 校验分享密码，必须在扣减下载次数之前
+	if transInfo.PasswordHash != "" {
+		if err := r.verifyPassword(ctx, transInfo, password); err != nil {
+			return transInfo, err
+		}
+	}
+
+	// 5. 原子兑换取件码并扣减剩余下载次数
+	remaining, err := redeemScript.Run(ctx, r.rClient, []string{pickupCode, DownloadLimitKey(fileUuid)}, fileUuid).Int64()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return transInfo, ErrPickupCodeExpired
+		}
+		return nil, err
+	}
 
-	// 生成15分钟有效的下载链接（可根据需求调整过期时间）
-	preSignedURL, err := utils.GeneratePreSignedDownloadURL(bucketName, objectName, 15*time.Minute)
+	// 6. 生成下载链接（预签名直链或代理下载链接，由配置决定；加密存储的分享凭取件码解包数据密钥，只能代理下载）
+	// 生成15分钟有效的下载链接
+	if transInfo.IsEncrypted() {
+		if err := UnwrapShareKey(transInfo, pickupCode); err != nil {
+			logger.Error("解包数据密钥失败", "err", err, "fileUuid", fileUuid)
+			return nil, err
+		}
+		if transInfo.IsInline() {
+			if transInfo.TextContent, err = openInlineText(transInfo); err != nil {
+				logger.Error("解密内联文本失败", "err", err, "fileUuid", fileUuid)
+				return nil, err
+			}
+		}
+	}
+	preSignedURL, downloadToken, err := trackedDownloadURL(ctx, transInfo)
 	if err != nil {
 		return nil, errors.New("生成文件下载链接失败!")
 	}
 	transInfo.StorageUrl = preSignedURL
+	transInfo.DownloadToken = downloadToken
+
+	// 多文件分享同时生成每个文件的下载链接（写入各文件的 StorageUrl）
+	if transInfo.IsBundle() {
+		files, err := r.shareFileDB.ListByFileUUID(fileUuid)
+		if err != nil {
+			return nil, err
+		}
+		for i := range files {
+			if files[i].StorageUrl, err = bundleFileURL(ctx, transInfo, downloadToken, &files[i]); err != nil {
+				return nil, errors.New("生成文件下载链接失败!")
+			}
+		}
+		transInfo.Files = files
+	}
+
+	// 7. 记录下载次数；次数用尽时内联文本已随本次响应返回，直接删除；文件在已发出的下载链接都下载完成后
+	// 由 DownloadService 直接删除，过期时间提前到下载链接失效之后，链接未被使用时由过期清理任务兜底
+	if err := r.transInfoDB.IncrementDownloadCount(fileUuid); err != nil {
+		logger.Error("更新下载次数失败", "err", err, "fileUuid", fileUuid)
+	}
+	transInfo.DownloadCount++
+	// 内联文本随本次响应返回，即视为取件完成
+	if transInfo.IsInline() {
+		if err := r.transInfoDB.UpdateReceiveInfo(fileUuid); err != nil {
+			logger.Error("更新取件状态失败", "err", err, "fileUuid", fileUuid)
+		}
+	}
+	if remaining == 0 {
+		expireAt := time.Now().Add(DownloadURLExpiry)
+		if err := r.transInfoDB.UpdateExpireAt(fileUuid, expireAt); err != nil {
+			logger.Error("更新过期时间失败", "err", err, "fileUuid", fileUuid)
+		}
+		transInfo.ExpireAt = expireAt
+		logger.Info("分享下载次数已用尽", "fileUuid", fileUuid, "maxDownloads", transInfo.MaxDownloads)
+		if transInfo.IsInline() {
+			r.reaper.Reap(transInfo)
+		}
+	}
 
 	return transInfo, nil
 }
 
-// UpDateFileStatus 当前端返回文件下载完成，更新文件状态
-func (r *ReceiveService) UpDateFileStatus(fileUuid string) error {
-	//取件成功，更新取件状态和时间
-	if err := r.transInfoDB.UpdateReceiveInfo(fileUuid); err != nil {
+// verifyPassword 校验分享密码，连续错误次数达到上限后在锁定时长内拒绝所有尝试
+func (r *ReceiveService) verifyPassword(ctx context.Context, transInfo *model.TransInfo, password string) error {
+	securityConf := conf.AppConfig.Security
+	attemptsKey := passwordAttemptsKeyPrefix + transInfo.FileUuid
+
+	// 未输入密码不计入尝试次数
+	if password == "" {
+		attempts, err := r.rClient.Get(ctx, attemptsKey).Int()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if attempts >= securityConf.PasswordMaxAttempts {
+			return ErrPasswordLocked
+		}
+		return ErrPasswordRequired
+	}
+
+	// 先原子地计入本次尝试，超过上限直接拒绝，不再进行bcrypt校验
+	attempts, err := passwordAttemptScript.Run(ctx, r.rClient, []string{attemptsKey},
+		securityConf.PasswordMaxAttempts, securityConf.PasswordLockout.Milliseconds()).Int()
+	if err != nil {
 		return err
 	}
+	if attempts > securityConf.PasswordMaxAttempts {
+		return ErrPasswordLocked
+	}
+
+	if !utils.CheckPassword(transInfo.PasswordHash, password) {
+		logger.Warn("分享密码错误", "fileUuid", transInfo.FileUuid, "attempts", attempts)
+		return ErrPasswordIncorrect
+	}
+
+	r.rClient.Del(ctx, attemptsKey)
 	return nil
 }
 
-// QueryReceiveRecords 查询今天接收的记录
-func (r *ReceiveService) QueryReceiveRecords() (interface{}, interface{}) {
-	// 1. 从数据库查询今天接收的记录
-	records, err := r.transInfoDB.QueryReceiveRecords()
+// VerifyChecksum 校验客户端对下载文件计算的SHA-256是否与上传时记录的一致，只返回是否一致；
+// downloadToken 为取件时返回的下载跟踪标识，与下载一样在下载链接有效期内且分享未撤销时才可校验；
+// seq 为多文件分享中的文件序号，单文件分享忽略
+func (r *ReceiveService) VerifyChecksum(ctx context.Context, downloadToken string, seq int, checksum string) (bool, error) {
+	fileUUID, err := r.rClient.HGet(ctx, downloadTrackKeyPrefix+downloadToken, "file").Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return false, ErrDownloadLinkInvalid
+		}
+		return false, err
+	}
+	transInfo, err := r.transInfoDB.GetByUUID(fileUUID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, ErrDownloadLinkInvalid
+		}
+		return false, err
+	}
+	if transInfo.IsRevoked {
+		return false, ErrShareRevoked
+	}
+
+	expected := transInfo.Sha256
+	if transInfo.IsBundle() {
+		file, err := r.shareFileDB.GetBySeq(fileUUID, seq)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return false, ErrShareNotFound
+			}
+			return false, err
+		}
+		expected = file.Sha256
+	}
+	if expected == "" {
+		return false, ErrChecksumUnavailable
+	}
+	return strings.EqualFold(expected, checksum), nil
+}
+
+// QueryReceiveRecords 按条件分页查询调用方接收的记录
+func (r *ReceiveService) QueryReceiveRecords(caller Caller, query *RecordQuery) (*RecordPage, error) {
+	filter, err := newRecordFilter(caller, query)
 	if err != nil {
 		return nil, err
 	}
-	return records, nil
+	// 多查一条用于判断是否还有下一页
+	filter.Limit++
+	received, err := r.transInfoDB.QueryReceiveRecords(filter)
+	if err != nil {
+		return nil, err
+	}
+	filter.Limit--
+
+	page := &RecordPage{Records: make([]ShareRecord, 0, len(received))}
+	for i := range received {
+		if i == filter.Limit {
+			last := &received[i-1]
+			page.NextCursor = encodeRecordCursor(filter, repository.RecordCursor{Time: last.ReceivedAt, Size: last.FileSize, ID: last.EventID})
+			break
+		}
+		record := newShareRecord(&received[i].TransInfo)
+		record.ReceiveAt = &received[i].ReceivedAt
+		page.Records = append(page.Records, record)
+	}
+	return page, nil
 }
@@ -1,89 +1,275 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"daoke.com/file_trans/conf"
 	"daoke.com/file_trans/database"
 	"daoke.com/file_trans/logger"
 	"daoke.com/file_trans/model"
 	"daoke.com/file_trans/repository"
+	"daoke.com/file_trans/storage"
+	"daoke.com/file_trans/utils"
+	"encoding/hex"
 	"fmt"
 	"github.com/google/uuid"
-	"github.com/minio/minio-go/v7"
 	"io"
 	"time"
 )
 
+// DownloadURLExpiry 下载链接（预签名直链或代理下载令牌）的有效期
+const DownloadURLExpiry = 15 * time.Minute
+
+// downloadLimitKeyPrefix 剩余下载次数在Redis中的键前缀
+const downloadLimitKeyPrefix = "downloads:"
+
+// pickupCodeKeyPrefix 文件到取件码的反向索引在Redis中的键前缀
+const pickupCodeKeyPrefix = "share_code:"
+
+// DownloadLimitKey 获取文件剩余下载次数的Redis键
+func DownloadLimitKey(fileUUID string) string {
+	return downloadLimitKeyPrefix + fileUUID
+}
+
+// PickupCodeKey 获取文件对应取件码的Redis键
+func PickupCodeKey(fileUUID string) string {
+	return pickupCodeKeyPrefix + fileUUID
+}
+
 type SendService struct {
 	transInfoDB *repository.TransInfoDAO
+	blobService *BlobService
 }
 
 // NewSendService 创建一个新的 SendService 实例
 func NewSendService() *SendService {
 	return &SendService{
 		transInfoDB: repository.NewTransInfoDAO(),
+		blobService: NewBlobService(),
 	}
 }
 
-// UploadToMinIO 通用上传方法，支持文本和文件
-func (s *SendService) UploadToMinIO(fileName string, fileSize int64, reader io.Reader, fileType string) (string, error) {
-	bucketName := conf.AppConfig.MinIO.BucketName
+// UploadResult 上传到存储后端的结果
+type UploadResult struct {
+	URL      string // 存储地址
+	Size     int64  // 实际写入的字节数（加密存储时为密文大小）
+	MimeType string // 内容类型（文件根据文件头识别）
+	Sha256   string // 明文内容的SHA-256（十六进制），写入存储时同步计算
 
+	Deduplicated bool // 内容已存在，URL 为已有的存储对象
+}
+
+// UploadToStorage 通用上传方法，支持文本和文件，写入配置的存储后端，边写入边计算SHA-256，
+// 内容与已有对象相同时复用已有对象（见 BlobService）；
+// 文件根据文件头识别内容类型并写入存储对象，类型不在允许范围内时返回 ErrFileTypeNotAllowed（端到端加密的密文不识别类型）；
+// fileSize 为 -1 时表示大小未知（如流式压缩包），返回实际写入的大小；
+// dataKey 不为空时以数据密钥分块加密后写入，加密的对象不参与去重，存储对象的内容类型统一为 application/octet-stream
+func (s *SendService) UploadToStorage(fileName string, fileSize int64, reader io.Reader, fileType string, dataKey []byte) (*UploadResult, error) {
 	// 根据类型决定存储路径和文件名
-	var objName string
-	var contentType string
+	objName, contentType := BuildObjectName(fileName, fileType)
 
-	if fileType == "text" {
-		// 文本文件：存储到texts目录，固定名称
-		objName = fmt.Sprintf("texts/%s", fileName)
-		contentType = "text/plain; charset=utf-8"
-	} else {
-		// 普通文件：存储到files目录，添加时间戳避免重名
-		objName = fmt.Sprintf("files/%d_%s", time.Now().UnixNano(), fileName)
-		contentType = "application/octet-stream"
+	// 端到端加密分享的密文无法识别类型，按原样保存
+	if fileType == "file" {
+		sniffed, sniffReader, err := utils.SniffContentType(reader)
+		if err != nil {
+			logger.Error("识别文件类型失败", "err", err, "file", fileName)
+			return nil, err
+		}
+		if err := CheckContentType(sniffed); err != nil {
+			logger.Warn("文件类型不在允许范围内", "file", fileName, "contentType", sniffed)
+			return nil, err
+		}
+		contentType, reader = sniffed, sniffReader
 	}
 
-	logger.Debug("开始上传文件到MinIO",
-		"bucket", bucketName,
+	logger.Debug("开始上传文件到存储",
 		"objName", objName,
 		"type", fileType,
+		"contentType", contentType,
 		"size", fileSize)
 
-	// 上传文件
-	_, err := database.MinIOClient.PutObject(
-		context.Background(),
-		bucketName,
-		objName,
-		reader,
-		fileSize,
-		minio.PutObjectOptions{ContentType: contentType},
-	)
+	// 上传文件（校验值按明文计算）
+	hasher := sha256.New()
+	reader = io.TeeReader(reader, hasher)
+	objContentType := contentType
+	if dataKey != nil {
+		encrypted, err := utils.NewEncryptReader(dataKey, reader)
+		if err != nil {
+			return nil, err
+		}
+		reader, fileSize, objContentType = encrypted, utils.EncryptedSize(fileSize), "application/octet-stream"
+	}
+	size, err := storage.Default.Put(context.Background(), objName, reader, fileSize, objContentType)
 	if err != nil {
-		logger.Error("MinIO文件上传失败", "err", err, "file", fileName, "type", fileType)
-		return "", err
+		logger.Error("文件上传失败", "err", err, "file", fileName, "type", fileType)
+		return nil, err
 	}
 
-	// 生成文件URL
-	endpoint := conf.AppConfig.MinIO.Endpoint
-	var fileURL string
-	if conf.AppConfig.MinIO.UseSSL {
-		fileURL = fmt.Sprintf("https://%s/%s/%s", endpoint, bucketName, objName)
-	} else {
-		fileURL = fmt.Sprintf("http://%s/%s/%s", endpoint, bucketName, objName)
+	// 生成文件URL，按内容哈希登记存储对象（加密的对象密文各不相同，不登记）
+	result := &UploadResult{URL: storage.Default.URL(objName), Size: size, MimeType: contentType, Sha256: hex.EncodeToString(hasher.Sum(nil))}
+	if dataKey == nil {
+		if err := s.blobService.Acquire(context.Background(), result); err != nil {
+			logger.Error("登记存储对象失败", "err", err, "file", fileName)
+			storage.Default.Delete(context.Background(), objName)
+			return nil, err
+		}
 	}
 
-	logger.Info("MinIO文件上传成功",
-		"fileURL", fileURL,
+	logger.Info("文件上传成功",
+		"fileURL", result.URL,
 		"fileName", fileName,
-		"type", fileType)
+		"type", fileType,
+		"contentType", contentType,
+		"size", size,
+		"encrypted", dataKey != nil,
+		"deduplicated", result.Deduplicated)
+
+	return result, nil
+}
+
+// InspectStoredObject 以Range请求读取已上传对象开头的若干字节识别内容类型，不读取整个对象
+// （分片上传、直传对象存储时文件内容不经过 UploadToStorage），类型不在允许范围内时返回 ErrFileTypeNotAllowed，
+// 失败时由调用方删除对象；
+// SHA-256 在分享创建后由 HashStoredObject 异步计算
+func (s *SendService) InspectStoredObject(fileURL string) (*UploadResult, error) {
+	ctx := context.Background()
+	objectName := storage.Default.ObjectName(fileURL)
+	info, err := storage.Default.Stat(ctx, objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	var head io.Reader = bytes.NewReader(nil)
+	if info.Size > 0 {
+		object, err := storage.Default.GetRange(ctx, objectName, 0, min(info.Size, utils.SniffLength))
+		if err != nil {
+			return nil, err
+		}
+		defer object.Close()
+		head = object
+	}
+	contentType, _, err := utils.SniffContentType(head)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckContentType(contentType); err != nil {
+		return nil, err
+	}
+	return &UploadResult{URL: fileURL, Size: info.Size, MimeType: contentType}, nil
+}
+
+// HashStoredObject 在后台读取已上传对象计算SHA-256并写入分享记录，不阻塞上传请求；
+// 计算完成前取件方查询不到校验值。这类对象不参与内容去重（在 blob 表中没有记录，释放时直接删除）
+func (s *SendService) HashStoredObject(fileUUID, fileURL string) {
+	go func() {
+		ctx := context.Background()
+		object, _, err := storage.Default.Get(ctx, storage.Default.ObjectName(fileURL))
+		if err != nil {
+			logger.Error("读取已上传文件计算SHA-256失败", "err", err, "fileUuid", fileUUID)
+			return
+		}
+		defer object.Close()
+
+		hasher := sha256.New()
+		if _, err := io.Copy(hasher, object); err != nil {
+			logger.Error("读取已上传文件计算SHA-256失败", "err", err, "fileUuid", fileUUID)
+			return
+		}
+		if err := s.transInfoDB.UpdateSha256(fileUUID, hex.EncodeToString(hasher.Sum(nil))); err != nil {
+			logger.Error("保存文件SHA-256失败", "err", err, "fileUuid", fileUUID)
+		}
+	}()
+}
 
-	return fileURL, nil
+// Discard 分享创建失败时释放已上传的存储对象（内容与其他分享共用时只减少引用计数）
+func (s *SendService) Discard(fileURL string) {
+	if err := s.blobService.Release(context.Background(), fileURL); err != nil {
+		logger.Error("删除已上传的文件失败", "err", err, "storageUrl", fileURL)
+	}
+}
+
+// TextContentType 文本分享的内容类型
+const TextContentType = "text/plain; charset=utf-8"
+
+// BuildObjectName 根据文件类型生成MinIO对象路径及Content-Type
+func BuildObjectName(fileName, fileType string) (objName, contentType string) {
+	if fileType == "text" {
+		// 文本文件：存储到texts目录，固定名称
+		return fmt.Sprintf("texts/%s", fileName), TextContentType
+	}
+	// 普通文件：存储到files目录，添加时间戳避免重名
+	return fmt.Sprintf("files/%d_%s", time.Now().UnixNano(), fileName), "application/octet-stream"
+}
+
+// AllocatePickupCode 为文件分配取件码并写入Redis
+// customCode 不为空时视为发送者指定的取件码，已被占用则返回 ErrPickupCodeConflict；
+// 否则由服务端随机生成，通过 SET NX 原子占用，冲突时重试
+func (s *SendService) AllocatePickupCode(customCode, fileUUID string, expiry time.Duration) (string, error) {
+	ctx := context.Background()
+	pickupConf := conf.AppConfig.Pickup
+
+	if customCode != "" {
+		if !utils.ValidatePickupCode(customCode, pickupConf.Length, pickupConf.Alphabet) {
+			return "", ErrPickupCodeInvalid
+		}
+		ok, err := s.claimPickupCode(ctx, customCode, fileUUID, expiry)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", ErrPickupCodeConflict
+		}
+		return customCode, nil
+	}
+
+	for i := 0; i < pickupConf.MaxRetries; i++ {
+		code, err := utils.GeneratePickupCode(pickupConf.Length, pickupConf.Alphabet)
+		if err != nil {
+			return "", err
+		}
+		ok, err := s.claimPickupCode(ctx, code, fileUUID, expiry)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return code, nil
+		}
+		logger.Debug("取件码冲突，重新生成", "attempt", i+1)
+	}
+	return "", ErrPickupCodeExhausted
 }
 
-// SaveToRedis 保存文件信息到Redis
-func (s *SendService) SaveToRedis(accessKey, fileUUID string, expiry time.Duration) error {
+// claimPickupCode 通过 SET NX 原子占用取件码，成功后记录文件到取件码的反向索引（用于撤销、延期）
+func (s *SendService) claimPickupCode(ctx context.Context, code, fileUUID string, expiry time.Duration) (bool, error) {
+	ok, err := database.RClient.SetNX(ctx, code, fileUUID, expiry).Result()
+	if err != nil || !ok {
+		return false, err
+	}
+	if err := database.RClient.Set(ctx, PickupCodeKey(fileUUID), code, expiry).Err(); err != nil {
+		database.RClient.Del(ctx, code)
+		return false, err
+	}
+	return true, nil
+}
+
+// SetDownloadLimit 在Redis中写入剩余下载次数，maxDownloads 为0表示不限次数
+func (s *SendService) SetDownloadLimit(fileUUID string, maxDownloads int, expiry time.Duration) error {
+	if maxDownloads <= 0 {
+		return nil
+	}
+	return database.RClient.Set(context.Background(), DownloadLimitKey(fileUUID), maxDownloads, expiry).Err()
+}
+
+// ReleasePickupCode 释放已分配的取件码（仅当其仍指向该文件时删除）及下载次数限制
+func (s *SendService) ReleasePickupCode(pickupCode, fileUUID string) {
 	ctx := context.Background()
-	return database.RClient.Set(ctx, accessKey, fileUUID, expiry).Err()
+	if pickupCode != "" {
+		if val, err := database.RClient.Get(ctx, pickupCode).Result(); err == nil && val == fileUUID {
+			database.RClient.Del(ctx, pickupCode)
+		}
+	}
+	database.RClient.Del(ctx, DownloadLimitKey(fileUUID), PickupCodeKey(fileUUID))
 }
 
 // SaveToDB 保存文件信息到数据库
@@ -96,12 +282,28 @@ func (s *SendService) GenerateFileUUID() string {
 	return uuid.New().String()
 }
 
-// QuerySendRecords 查询今天发送的记录
-func (s *SendService) QuerySendRecords() ([]model.TransInfo, error) {
-	return s.transInfoDB.QuerySendRecords()
-}
+// QuerySendRecords 按条件分页查询调用方发送的记录
+func (s *SendService) QuerySendRecords(caller Caller, query *RecordQuery) (*RecordPage, error) {
+	filter, err := newRecordFilter(caller, query)
+	if err != nil {
+		return nil, err
+	}
+	// 多查一条用于判断是否还有下一页
+	filter.Limit++
+	transInfos, err := s.transInfoDB.QuerySendRecords(filter)
+	if err != nil {
+		return nil, err
+	}
+	filter.Limit--
 
-// UpdateSendStatus 更新文件发送状态
-func (s *SendService) UpdateSendStatus(fileUUID string) error {
-	return s.transInfoDB.UpdateSendStatus(fileUUID)
+	page := &RecordPage{Records: make([]ShareRecord, 0, len(transInfos))}
+	for i := range transInfos {
+		if i == filter.Limit {
+			last := &transInfos[i-1]
+			page.NextCursor = encodeRecordCursor(filter, repository.RecordCursor{Time: last.CreatedAt, Size: last.FileSize, ID: last.ID})
+			break
+		}
+		page.Records = append(page.Records, newShareRecord(&transInfos[i]))
+	}
+	return page, nil
 }
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"daoke.com/file_trans/model"
+	"daoke.com/file_trans/repository"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// Caller 请求调用方：登录用户按用户ID识别，匿名用户按后端签发的设备标识识别
+type Caller struct {
+	UserID     uint   // 登录用户ID（0表示匿名）
+	DeviceHash string // 设备标识的SHA-256哈希
+}
+
+// ShareRecord 发送、取件记录的对外视图，不包含存储地址、密码哈希等内部字段
+type ShareRecord struct {
+	FileUuid      string     `json:"fileUuid"`
+	FileName      string     `json:"fileName"`
+	FileType      string     `json:"fileType"`
+	FileSize      int64      `json:"fileSize"`
+	FileCount     int        `json:"fileCount"`
+	MimeType      string     `json:"mimeType"`
+	Category      string     `json:"category"`
+	Sha256        string     `json:"sha256"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	ExpireAt      time.Time  `json:"expireAt"`
+	Expired       bool       `json:"expired"`
+	Revoked       bool       `json:"revoked"`
+	HasPassword   bool       `json:"hasPassword"`
+	Encrypted     bool       `json:"encrypted"`
+	MaxDownloads  int        `json:"maxDownloads"`
+	DownloadCount int        `json:"downloadCount"`
+	ReceiveAt     *time.Time `json:"receiveAt,omitempty"` // 取件时间（仅取件记录）
+}
+
+// newShareRecord 将分享记录转换为对外视图
+func newShareRecord(transInfo *model.TransInfo) ShareRecord {
+	return ShareRecord{
+		FileUuid:      transInfo.FileUuid,
+		FileName:      transInfo.FileName,
+		FileType:      transInfo.FileType,
+		FileSize:      transInfo.FileSize,
+		FileCount:     transInfo.FileCount,
+		MimeType:      transInfo.MimeType,
+		Category:      transInfo.Category,
+		Sha256:        transInfo.Sha256,
+		CreatedAt:     transInfo.CreatedAt,
+		ExpireAt:      transInfo.ExpireAt,
+		Expired:       transInfo.IsExpire || (!transInfo.ExpireAt.IsZero() && time.Now().After(transInfo.ExpireAt)),
+		Revoked:       transInfo.IsRevoked,
+		HasPassword:   transInfo.PasswordHash != "",
+		Encrypted:     transInfo.IsEncrypted(),
+		MaxDownloads:  transInfo.MaxDownloads,
+		DownloadCount: transInfo.DownloadCount,
+	}
+}
+
+// 分页条数
+const (
+	defaultRecordLimit = 20
+	maxRecordLimit     = 100
+)
+
+// RecordQuery 记录查询参数
+type RecordQuery struct {
+	From     time.Time // 起始时间（含），零值表示不限
+	To       time.Time // 截止时间（不含），零值表示不限
+	FileType string    // 类型（text/file/e2e）
+	Keyword  string    // 文件名关键字
+	Status   string    // 状态（active/expired/revoked）
+	SortBy   string    // 排序字段（time/size）
+	Asc      bool      // 是否升序
+	Limit    int       // 每页条数
+	Cursor   string    // 上一页返回的游标
+}
+
+// RecordPage 分页查询结果
+type RecordPage struct {
+	Records    []ShareRecord `json:"records"`
+	NextCursor string        `json:"nextCursor,omitempty"` // 下一页游标，为空表示没有更多记录
+}
+
+// recordCursor 游标内容，同时记录排序方式，防止切换排序后沿用旧游标
+type recordCursor struct {
+	SortBy string `json:"by"`
+	Asc    bool   `json:"asc,omitempty"`
+	repository.RecordCursor
+}
+
+// newRecordFilter 根据调用方和查询参数构造数据库查询条件
+func newRecordFilter(caller Caller, query *RecordQuery) (*repository.RecordFilter, error) {
+	filter := &repository.RecordFilter{
+		UserID:     caller.UserID,
+		DeviceHash: caller.DeviceHash,
+		From:       query.From,
+		To:         query.To,
+		FileType:   query.FileType,
+		Keyword:    query.Keyword,
+		Status:     query.Status,
+		SortBy:     query.SortBy,
+		Asc:        query.Asc,
+		Limit:      query.Limit,
+	}
+	if filter.SortBy == "" {
+		filter.SortBy = repository.RecordSortTime
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultRecordLimit
+	}
+	filter.Limit = min(filter.Limit, maxRecordLimit)
+
+	if query.Cursor != "" {
+		data, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		var cursor recordCursor
+		if err := json.Unmarshal(data, &cursor); err != nil || cursor.SortBy != filter.SortBy || cursor.Asc != filter.Asc {
+			return nil, ErrInvalidCursor
+		}
+		filter.Cursor = &cursor.RecordCursor
+	}
+	return filter, nil
+}
+
+// encodeRecordCursor 根据本页最后一条记录生成下一页游标
+func encodeRecordCursor(filter *repository.RecordFilter, position repository.RecordCursor) string {
+	data, _ := json.Marshal(recordCursor{SortBy: filter.SortBy, Asc: filter.Asc, RecordCursor: position})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
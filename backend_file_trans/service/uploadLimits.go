@@ -0,0 +1,129 @@
+package service
+
+import (
+	"daoke.com/file_trans/conf"
+	"encoding/base64"
+	"mime"
+	"path"
+	"strings"
+)
+
+// MaxFileSize 单个文件大小上限（字节）
+func MaxFileSize() int64 {
+	return conf.AppConfig.Upload.MaxFileSizeMB << 20
+}
+
+// MaxRequestSize 表单发送的请求体大小上限（字节）
+func MaxRequestSize() int64 {
+	return conf.AppConfig.Upload.MaxRequestSizeMB << 20
+}
+
+// MaxTextSize 文本内容大小上限（字节）
+func MaxTextSize() int64 {
+	return conf.AppConfig.Upload.MaxTextSizeMB << 20
+}
+
+// InlineTextMaxSize 内联保存的文本大小上限（字节）
+func InlineTextMaxSize() int64 {
+	return conf.AppConfig.Upload.InlineTextMaxKB << 10
+}
+
+// CheckFileCount 校验单次发送的文件数
+func CheckFileCount(count int) error {
+	if count > conf.AppConfig.Upload.MaxFiles {
+		return ErrTooManyFiles
+	}
+	return nil
+}
+
+// CheckTextSize 校验文本内容大小
+func CheckTextSize(size int64) error {
+	if size > MaxTextSize() {
+		return ErrTextTooLarge
+	}
+	return nil
+}
+
+// CheckUploadFile 按配置校验文件大小、扩展名及MIME类型（contentType 为空时不校验MIME类型）
+func CheckUploadFile(fileName string, fileSize int64, contentType string) error {
+	uploadConf := conf.AppConfig.Upload
+	if fileSize > MaxFileSize() {
+		return ErrFileTooLarge
+	}
+	if len(uploadConf.AllowedExtensions) > 0 && !extensionAllowed(fileName, uploadConf.AllowedExtensions) {
+		return ErrFileTypeNotAllowed
+	}
+	if contentType != "" {
+		return CheckContentType(contentType)
+	}
+	return nil
+}
+
+// MaxEncryptedMetaSize 端到端加密分享的加密元数据长度上限（base64编码后的字符数）
+const MaxEncryptedMetaSize = 4096
+
+// CheckEncryptedUpload 校验端到端加密分享的密文大小；密文无法识别类型，配置了扩展名或MIME类型白名单时不允许端到端加密分享
+func CheckEncryptedUpload(fileSize int64) error {
+	uploadConf := conf.AppConfig.Upload
+	if fileSize > MaxFileSize() {
+		return ErrFileTooLarge
+	}
+	if len(uploadConf.AllowedExtensions) > 0 || len(uploadConf.AllowedMimeTypes) > 0 {
+		return ErrFileTypeNotAllowed
+	}
+	return nil
+}
+
+// CheckEncryptedMeta 校验端到端加密分享的加密元数据：不能为空、不超过长度上限，且为base64编码（标准或URL安全，可省略填充）
+func CheckEncryptedMeta(meta string) error {
+	if meta == "" || len(meta) > MaxEncryptedMetaSize {
+		return ErrEncryptedMetaInvalid
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if _, err := encoding.DecodeString(meta); err == nil {
+			return nil
+		}
+	}
+	return ErrEncryptedMetaInvalid
+}
+
+// CheckContentType 校验MIME类型是否在允许范围内
+func CheckContentType(contentType string) error {
+	allowed := conf.AppConfig.Upload.AllowedMimeTypes
+	if len(allowed) > 0 && !mimeTypeAllowed(contentType, allowed) {
+		return ErrFileTypeNotAllowed
+	}
+	return nil
+}
+
+// extensionAllowed 判断文件扩展名是否在允许列表中（忽略大小写，配置中的扩展名可省略开头的点）
+func extensionAllowed(fileName string, allowed []string) bool {
+	ext := strings.ToLower(path.Ext(fileName))
+	if ext == "" {
+		return false
+	}
+	for _, item := range allowed {
+		if "."+strings.TrimPrefix(strings.ToLower(item), ".") == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// mimeTypeAllowed 判断MIME类型是否在允许列表中，支持 image/* 形式的通配
+func mimeTypeAllowed(contentType string, allowed []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, item := range allowed {
+		item = strings.ToLower(item)
+		if item == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(item, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
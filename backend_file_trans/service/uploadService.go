@@ -0,0 +1,353 @@
+package service
+
+import (
+	"context"
+	"daoke.com/file_trans/conf"
+	"daoke.com/file_trans/database"
+	"daoke.com/file_trans/logger"
+	"daoke.com/file_trans/storage"
+	"daoke.com/file_trans/utils"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/redis/go-redis/v9"
+	"io"
+	"mime"
+	"sort"
+	"time"
+)
+
+// uploadSessionKeyPrefix 分片上传会话在Redis中的键前缀
+const uploadSessionKeyPrefix = "upload:"
+
+// presignKeyPrefix 直传对象存储凭证在Redis中的键前缀
+const presignKeyPrefix = "presign:"
+
+// presignObjectsKey 直传凭证允许写入的对象路径（有序集合，分值为凭证失效时间），凭证失效后由过期清理任务删除这些路径上的对象
+const presignObjectsKey = "presign_objects"
+
+// maxUploadParts S3协议允许的最大分片数
+const maxUploadParts = 10000
+
+// UploadSession 分片上传会话，保存在Redis中
+type UploadSession struct {
+	UploadID   string `json:"uploadId"`   // 会话标识（返回给客户端）
+	MinIOID    string `json:"minioId"`    // MinIO multipart uploadId
+	ObjectName string `json:"objectName"` // MinIO对象路径
+	FileName   string `json:"fileName"`   // 原始文件名
+	FileSize   int64  `json:"fileSize"`   // 文件总大小（字节）
+	PartSize   int64  `json:"partSize"`   // 分片大小（字节）
+	TotalParts int    `json:"totalParts"` // 分片总数
+}
+
+// UploadedPart 已上传的分片信息
+type UploadedPart struct {
+	PartNumber int    `json:"partNumber"`
+	Size       int64  `json:"size"`
+	ETag       string `json:"etag"`
+}
+
+// PresignedUpload 直传对象存储的上传凭证，保存在Redis中，确认上传时据此校验对象
+type PresignedUpload struct {
+	UploadID    string            `json:"uploadId"`    // 凭证标识（返回给客户端）
+	ObjectName  string            `json:"objectName"`  // 允许写入的对象路径
+	FileName    string            `json:"fileName"`    // 原始文件名
+	FileSize    int64             `json:"fileSize"`    // 声明的文件大小（字节）
+	ContentType string            `json:"contentType"` // 声明的Content-Type
+	URL         string            `json:"url"`         // 上传地址
+	FormData    map[string]string `json:"formData"`    // 需随文件一起提交的表单字段
+	ExpiresAt   time.Time         `json:"expiresAt"`   // 凭证过期时间
+}
+
+// UploadService 分片上传与预签名直传，依赖MinIO的multipart和POST策略，本地存储后端不支持
+type UploadService struct {
+	rClient    *redis.Client
+	minioStore *storage.MinIOStore // 非MinIO存储后端时为nil
+	core       minio.Core
+}
+
+// NewUploadService 创建一个新的 UploadService 实例
+func NewUploadService() *UploadService {
+	u := &UploadService{
+		rClient: database.RClient,
+	}
+	if minioStore, ok := storage.Default.(*storage.MinIOStore); ok {
+		u.minioStore = minioStore
+		u.core = minio.Core{Client: minioStore.Client()}
+	}
+	return u
+}
+
+// Supported 当前存储后端是否支持分片上传与预签名直传
+func (u *UploadService) Supported() bool {
+	return u.minioStore != nil
+}
+
+// InitUpload 初始化分片上传：在MinIO创建multipart上传并保存会话
+func (u *UploadService) InitUpload(fileName string, fileSize int64) (*UploadSession, error) {
+	if !u.Supported() {
+		return nil, ErrStorageUnsupported
+	}
+	if fileName == "" || fileSize <= 0 {
+		return nil, ErrUploadInvalidParams
+	}
+
+	partSize := int64(conf.AppConfig.Upload.ChunkSizeMB) * 1024 * 1024
+	totalParts := int((fileSize + partSize - 1) / partSize)
+	if totalParts > maxUploadParts {
+		return nil, ErrUploadTooManyParts
+	}
+	objName, contentType := BuildObjectName(fileName, "file")
+
+	minioID, err := u.core.NewMultipartUpload(context.Background(), u.minioStore.BucketName(), objName,
+		minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return nil, err
+	}
+
+	session := &UploadSession{
+		UploadID:   uuid.New().String(),
+		MinIOID:    minioID,
+		ObjectName: objName,
+		FileName:   fileName,
+		FileSize:   fileSize,
+		PartSize:   partSize,
+		TotalParts: totalParts,
+	}
+	if err := u.saveSession(session); err != nil {
+		_ = u.core.AbortMultipartUpload(context.Background(), u.minioStore.BucketName(), objName, minioID)
+		return nil, err
+	}
+
+	logger.Info("分片上传会话已创建", "uploadId", session.UploadID, "file", fileName, "parts", session.TotalParts)
+	return session, nil
+}
+
+// GetSession 获取分片上传会话
+func (u *UploadService) GetSession(uploadID string) (*UploadSession, error) {
+	data, err := u.rClient.Get(context.Background(), uploadSessionKeyPrefix+uploadID).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+	var session UploadSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// UploadPart 上传单个分片，除最后一个分片外大小必须等于会话的分片大小
+func (u *UploadService) UploadPart(session *UploadSession, partNumber int, reader io.Reader, size int64) (*UploadedPart, error) {
+	if partNumber < 1 || partNumber > session.TotalParts || size != session.expectedPartSize(partNumber) {
+		return nil, ErrUploadInvalidPart
+	}
+
+	part, err := u.core.PutObjectPart(context.Background(), u.minioStore.BucketName(), session.ObjectName,
+		session.MinIOID, partNumber, reader, size, minio.PutObjectPartOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &UploadedPart{PartNumber: part.PartNumber, Size: part.Size, ETag: part.ETag}, nil
+}
+
+// ListParts 查询已上传到MinIO的分片，用于断点续传
+func (u *UploadService) ListParts(session *UploadSession) ([]UploadedPart, error) {
+	var parts []UploadedPart
+	marker := 0
+	for {
+		result, err := u.core.ListObjectParts(context.Background(), u.minioStore.BucketName(),
+			session.ObjectName, session.MinIOID, marker, 1000)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range result.ObjectParts {
+			parts = append(parts, UploadedPart{PartNumber: p.PartNumber, Size: p.Size, ETag: p.ETag})
+		}
+		if !result.IsTruncated {
+			break
+		}
+		marker = result.NextPartNumberMarker
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts, nil
+}
+
+// CompleteUpload 校验所有分片均已上传后合并为完整对象，返回存储地址
+func (u *UploadService) CompleteUpload(session *UploadSession) (string, error) {
+	parts, err := u.ListParts(session)
+	if err != nil {
+		return "", err
+	}
+	if len(parts) != session.TotalParts {
+		return "", ErrUploadIncomplete
+	}
+
+	completeParts := make([]minio.CompletePart, 0, len(parts))
+	for i, p := range parts {
+		if p.PartNumber != i+1 || p.Size != session.expectedPartSize(p.PartNumber) {
+			return "", ErrUploadIncomplete
+		}
+		completeParts = append(completeParts, minio.CompletePart{PartNumber: p.PartNumber, ETag: p.ETag})
+	}
+
+	_, err = u.core.CompleteMultipartUpload(context.Background(), u.minioStore.BucketName(),
+		session.ObjectName, session.MinIOID, completeParts, minio.PutObjectOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	u.deleteSession(session.UploadID)
+	logger.Info("分片上传完成", "uploadId", session.UploadID, "object", session.ObjectName)
+	return u.minioStore.URL(session.ObjectName), nil
+}
+
+// AbortUpload 取消分片上传，删除MinIO中已上传的分片及会话
+func (u *UploadService) AbortUpload(session *UploadSession) error {
+	err := u.core.AbortMultipartUpload(context.Background(), u.minioStore.BucketName(),
+		session.ObjectName, session.MinIOID)
+	if err != nil {
+		return err
+	}
+	u.deleteSession(session.UploadID)
+	logger.Info("分片上传已取消", "uploadId", session.UploadID)
+	return nil
+}
+
+// PresignUpload 生成直传对象存储的预签名POST策略，限定对象路径、大小和Content-Type
+func (u *UploadService) PresignUpload(fileName string, fileSize int64, contentType string) (*PresignedUpload, error) {
+	if !u.Supported() {
+		return nil, ErrStorageUnsupported
+	}
+	if fileName == "" || fileSize <= 0 {
+		return nil, ErrUploadInvalidParams
+	}
+
+	objName, defaultContentType := BuildObjectName(fileName, "file")
+	if contentType == "" {
+		contentType = defaultContentType
+	} else if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		return nil, ErrUploadInvalidParams
+	}
+
+	expiry := conf.AppConfig.Upload.PresignExpiry
+	uploadURL, formData, err := utils.GeneratePreSignedUploadPolicy(u.minioStore.Client(), u.minioStore.BucketName(), objName,
+		fileSize, contentType, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	presigned := &PresignedUpload{
+		UploadID:    uuid.New().String(),
+		ObjectName:  objName,
+		FileName:    fileName,
+		FileSize:    fileSize,
+		ContentType: contentType,
+		URL:         uploadURL,
+		FormData:    formData,
+		ExpiresAt:   time.Now().Add(expiry),
+	}
+	data, err := json.Marshal(presigned)
+	if err != nil {
+		return nil, err
+	}
+	// 凭证过期后仍需留出确认时间，因此保存时长与分片上传会话一致
+	ttl := expiry + conf.AppConfig.Upload.SessionTTL
+	pipe := u.rClient.TxPipeline()
+	pipe.Set(context.Background(), presignKeyPrefix+presigned.UploadID, data, ttl)
+	pipe.ZAdd(context.Background(), presignObjectsKey, redis.Z{Score: float64(time.Now().Add(ttl).Unix()), Member: objName})
+	if _, err := pipe.Exec(context.Background()); err != nil {
+		return nil, err
+	}
+
+	logger.Info("已生成直传上传凭证", "uploadId", presigned.UploadID, "object", objName, "size", fileSize)
+	return presigned, nil
+}
+
+// GetPresignedUpload 获取直传上传凭证
+func (u *UploadService) GetPresignedUpload(uploadID string) (*PresignedUpload, error) {
+	data, err := u.rClient.Get(context.Background(), presignKeyPrefix+uploadID).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+	var presigned PresignedUpload
+	if err := json.Unmarshal(data, &presigned); err != nil {
+		return nil, err
+	}
+	return &presigned, nil
+}
+
+// ConfirmPresignedUpload 通过 StatObject 校验客户端已按凭证完成上传，再在存储后端内复制到服务端生成的新路径，返回副本的存储地址：
+// 上传策略在有效期内仍可写入原路径，分享只引用副本，确认后对原路径的写入不影响已校验的内容（原路径由过期清理任务删除）。
+// 对象不存在时返回 ErrUploadIncomplete；大小或类型与声明不符、复制前已被覆盖时返回 ErrUploadMismatch
+func (u *UploadService) ConfirmPresignedUpload(presigned *PresignedUpload) (string, error) {
+	ctx := context.Background()
+	info, err := u.minioStore.Stat(ctx, presigned.ObjectName)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			return "", ErrUploadIncomplete
+		}
+		return "", err
+	}
+
+	if info.Size != presigned.FileSize || info.ContentType != presigned.ContentType {
+		logger.Warn("直传对象与声明不符，已删除", "uploadId", presigned.UploadID,
+			"size", info.Size, "contentType", info.ContentType)
+		if err := u.minioStore.Delete(ctx, presigned.ObjectName); err != nil {
+			logger.Error("删除不符的直传对象失败", "err", err, "object", presigned.ObjectName)
+		}
+		u.rClient.Del(context.Background(), presignKeyPrefix+presigned.UploadID)
+		return "", ErrUploadMismatch
+	}
+
+	// 删除凭证，删除成功的一方才能创建分享，避免重复确认生成多个分享
+	deleted, err := u.rClient.Del(context.Background(), presignKeyPrefix+presigned.UploadID).Result()
+	if err != nil {
+		return "", err
+	}
+	if deleted == 0 {
+		return "", ErrUploadNotFound
+	}
+
+	// 按ETag复制，保证副本正是上面校验过的对象
+	objName, _ := BuildObjectName(presigned.FileName, "file")
+	if err := u.minioStore.Copy(ctx, presigned.ObjectName, objName, info.ETag); err != nil {
+		if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+			return "", ErrUploadMismatch
+		}
+		return "", err
+	}
+	if err := u.minioStore.Delete(ctx, presigned.ObjectName); err != nil {
+		logger.Error("删除直传原路径对象失败", "err", err, "object", presigned.ObjectName)
+	}
+	return u.minioStore.URL(objName), nil
+}
+
+// saveSession 保存会话到Redis
+func (u *UploadService) saveSession(session *UploadSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return u.rClient.Set(context.Background(), uploadSessionKeyPrefix+session.UploadID, data,
+		conf.AppConfig.Upload.SessionTTL).Err()
+}
+
+// deleteSession 删除Redis中的会话
+func (u *UploadService) deleteSession(uploadID string) {
+	u.rClient.Del(context.Background(), uploadSessionKeyPrefix+uploadID)
+}
+
+// expectedPartSize 计算指定分片应有的大小
+func (s *UploadSession) expectedPartSize(partNumber int) int64 {
+	if partNumber == s.TotalParts {
+		return s.FileSize - int64(s.TotalParts-1)*s.PartSize
+	}
+	return s.PartSize
+}
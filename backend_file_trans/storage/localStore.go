@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"daoke.com/file_trans/conf"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// localURLScheme 本地存储地址的协议前缀，格式为 local:///{object}
+const localURLScheme = "local://"
+
+// LocalDownloadPath 本地存储签名下载链接由Gin应用自身提供的路由
+const LocalDownloadPath = "/api/v1/storage/download"
+
+var (
+	// ErrInvalidSignature 下载链接签名无效
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrLinkExpired 下载链接已过期
+	ErrLinkExpired = errors.New("link expired")
+)
+
+// LocalStore 基于本地文件系统的对象存储实现，下载链接为HMAC签名、有时效的应用内链接
+type LocalStore struct {
+	root      string // 存储根目录
+	publicURL string // 后端对外访问地址
+	secret    []byte // 下载链接签名密钥
+}
+
+// NewLocalStore 创建一个新的 LocalStore 实例
+func NewLocalStore(localConf conf.LocalStorageConfig) (*LocalStore, error) {
+	if localConf.SigningSecret == "" {
+		return nil, errors.New("local storage signing_secret is required")
+	}
+	root, err := filepath.Abs(localConf.Root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalStore{
+		root:      root,
+		publicURL: strings.TrimSuffix(localConf.PublicURL, "/"),
+		secret:    []byte(localConf.SigningSecret),
+	}, nil
+}
+
+// Put 写入对象，先写入临时文件再重命名，避免读到未写完的文件
+func (l *LocalStore) Put(ctx context.Context, objectName string, reader io.Reader, size int64, contentType string) (int64, error) {
+	fullPath, err := l.resolve(objectName)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fullPath), ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, reader)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if size >= 0 && written != size {
+		return 0, fmt.Errorf("size mismatch: expected %d, written %d", size, written)
+	}
+
+	if err := os.Rename(tmp.Name(), fullPath); err != nil {
+		return 0, err
+	}
+	return written, nil
+}
+
+// Get 读取对象
+func (l *LocalStore) Get(ctx context.Context, objectName string) (io.ReadSeekCloser, ObjectInfo, error) {
+	fullPath, err := l.resolve(objectName)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, ObjectInfo{}, convertOSErr(err)
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, ObjectInfo{}, err
+	}
+	return file, fileObjectInfo(objectName, stat), nil
+}
+
+// GetRange 读取对象的一部分
+func (l *LocalStore) GetRange(ctx context.Context, objectName string, offset, length int64) (io.ReadCloser, error) {
+	fullPath, err := l.resolve(objectName)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, convertOSErr(err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &rangeReader{Reader: io.LimitReader(file, length), Closer: file}, nil
+}
+
+// rangeReader 读取文件的一部分，关闭时关闭文件
+type rangeReader struct {
+	io.Reader
+	io.Closer
+}
+
+// Stat 查询对象元信息
+func (l *LocalStore) Stat(ctx context.Context, objectName string) (ObjectInfo, error) {
+	fullPath, err := l.resolve(objectName)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	stat, err := os.Stat(fullPath)
+	if err != nil {
+		return ObjectInfo{}, convertOSErr(err)
+	}
+	return fileObjectInfo(objectName, stat), nil
+}
+
+// Delete 删除对象，对象不存在时视为成功
+func (l *LocalStore) Delete(ctx context.Context, objectName string) error {
+	fullPath, err := l.resolve(objectName)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// PresignGet 生成由应用自身提供的签名下载链接，下载文件名同样参与签名
+func (l *LocalStore) PresignGet(ctx context.Context, objectName, fileName string, expiry time.Duration) (string, error) {
+	return l.PresignTrackedGet(ctx, objectName, fileName, "", expiry)
+}
+
+// PresignTrackedGet 生成签名下载链接，跟踪标识放在 track 参数中，同样参与签名
+func (l *LocalStore) PresignTrackedGet(ctx context.Context, objectName, fileName, trackingID string, expiry time.Duration) (string, error) {
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	query := url.Values{}
+	query.Set("object", objectName)
+	query.Set("name", fileName)
+	query.Set("expires", expires)
+	if trackingID != "" {
+		query.Set("track", trackingID)
+	}
+	query.Set("sig", l.sign(objectName, fileName, expires, trackingID))
+	return l.publicURL + LocalDownloadPath + "?" + query.Encode(), nil
+}
+
+// URL 生成对象的存储地址
+func (l *LocalStore) URL(objectName string) string {
+	return localURLScheme + "/" + objectName
+}
+
+// ObjectName 从存储地址中解析对象路径
+func (l *LocalStore) ObjectName(storageURL string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(storageURL, localURLScheme), "/")
+}
+
+// Verify 校验签名下载链接的签名和有效期
+func (l *LocalStore) Verify(objectName, fileName, expires, trackingID, sig string) error {
+	expected := l.sign(objectName, fileName, expires, trackingID)
+	if !hmac.Equal([]byte(expected), []byte(sig)) {
+		return ErrInvalidSignature
+	}
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if time.Now().Unix() > expiresAt {
+		return ErrLinkExpired
+	}
+	return nil
+}
+
+// sign 计算 HMAC-SHA256(对象路径 + 下载文件名 + 过期时间 [+ 跟踪标识])
+func (l *LocalStore) sign(objectName, fileName, expires, trackingID string) string {
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write([]byte(objectName + "\n" + fileName + "\n" + expires))
+	if trackingID != "" {
+		mac.Write([]byte("\n" + trackingID))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// resolve 将对象路径映射为根目录下的文件路径，拒绝越出根目录的路径
+func (l *LocalStore) resolve(objectName string) (string, error) {
+	cleaned := path.Clean("/" + objectName)
+	if cleaned == "/" {
+		return "", ErrObjectNotFound
+	}
+	fullPath := filepath.Join(l.root, filepath.FromSlash(cleaned))
+	if !strings.HasPrefix(fullPath, l.root+string(filepath.Separator)) {
+		return "", ErrObjectNotFound
+	}
+	return fullPath, nil
+}
+
+// convertOSErr 将文件不存在错误转换为 ErrObjectNotFound
+func convertOSErr(err error) error {
+	if os.IsNotExist(err) {
+		return ErrObjectNotFound
+	}
+	return err
+}
+
+// fileObjectInfo 根据文件信息生成对象元信息，Content-Type 按扩展名推断
+func fileObjectInfo(objectName string, stat os.FileInfo) ObjectInfo {
+	contentType := mime.TypeByExtension(path.Ext(objectName))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return ObjectInfo{
+		Size:         stat.Size(),
+		ContentType:  contentType,
+		ETag:         fmt.Sprintf(`"%x-%x"`, stat.ModTime().UnixNano(), stat.Size()),
+		LastModified: stat.ModTime(),
+	}
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"daoke.com/file_trans/conf"
+	"daoke.com/file_trans/utils"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"io"
+	"mime"
+	"net/url"
+	"time"
+)
+
+// MinIOStore 基于MinIO的对象存储实现
+type MinIOStore struct {
+	client     *minio.Client
+	bucketName string
+}
+
+// NewMinIOStore 创建一个新的 MinIOStore 实例
+func NewMinIOStore(client *minio.Client, bucketName string) *MinIOStore {
+	return &MinIOStore{
+		client:     client,
+		bucketName: bucketName,
+	}
+}
+
+// Put 写入对象，分片大小固定，保证大小未知时内存占用不随文件大小增长
+func (m *MinIOStore) Put(ctx context.Context, objectName string, reader io.Reader, size int64, contentType string) (int64, error) {
+	info, err := m.client.PutObject(ctx, m.bucketName, objectName, reader, size, minio.PutObjectOptions{
+		ContentType: contentType,
+		PartSize:    uint64(conf.AppConfig.Upload.ChunkSizeMB) * 1024 * 1024,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// Get 读取对象
+func (m *MinIOStore) Get(ctx context.Context, objectName string) (io.ReadSeekCloser, ObjectInfo, error) {
+	object, err := m.client.GetObject(ctx, m.bucketName, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, ObjectInfo{}, m.convertErr(err)
+	}
+	info, err := object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, ObjectInfo{}, m.convertErr(err)
+	}
+	return object, toObjectInfo(info), nil
+}
+
+// GetRange 以Range请求读取对象的一部分
+func (m *MinIOStore) GetRange(ctx context.Context, objectName string, offset, length int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return nil, err
+	}
+	object, err := m.client.GetObject(ctx, m.bucketName, objectName, opts)
+	if err != nil {
+		return nil, m.convertErr(err)
+	}
+	return object, nil
+}
+
+// Stat 查询对象元信息
+func (m *MinIOStore) Stat(ctx context.Context, objectName string) (ObjectInfo, error) {
+	info, err := m.client.StatObject(ctx, m.bucketName, objectName, minio.StatObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, m.convertErr(err)
+	}
+	return toObjectInfo(info), nil
+}
+
+// Delete 删除对象
+func (m *MinIOStore) Delete(ctx context.Context, objectName string) error {
+	return m.client.RemoveObject(ctx, m.bucketName, objectName, minio.RemoveObjectOptions{})
+}
+
+// Copy 在存储后端内复制对象，数据不经过后端；源对象的ETag与 matchETag 不一致（已被覆盖）时失败
+func (m *MinIOStore) Copy(ctx context.Context, srcObject, dstObject, matchETag string) error {
+	_, err := m.client.ComposeObject(ctx,
+		minio.CopyDestOptions{Bucket: m.bucketName, Object: dstObject},
+		minio.CopySrcOptions{Bucket: m.bucketName, Object: srcObject, MatchETag: matchETag})
+	if err != nil {
+		return m.convertErr(err)
+	}
+	return nil
+}
+
+// PresignGet 生成MinIO预签名下载链接，通过 response-content-disposition 指定下载文件名
+func (m *MinIOStore) PresignGet(ctx context.Context, objectName, fileName string, expiry time.Duration) (string, error) {
+	return m.PresignTrackedGet(ctx, objectName, fileName, "", expiry)
+}
+
+// PresignTrackedGet 生成附带跟踪标识（参与签名，不可篡改）的预签名下载链接，trackingID 为空时不附带
+func (m *MinIOStore) PresignTrackedGet(ctx context.Context, objectName, fileName, trackingID string, expiry time.Duration) (string, error) {
+	reqParams := url.Values{}
+	if fileName != "" {
+		reqParams.Set("response-content-disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	}
+	if trackingID != "" {
+		reqParams.Set(TrackQueryParam, trackingID)
+	}
+	preSignedURL, err := m.client.PresignedGetObject(ctx, m.bucketName, objectName, expiry, reqParams)
+	if err != nil {
+		return "", err
+	}
+	return preSignedURL.String(), nil
+}
+
+// URL 生成对象的存储地址，格式为 http(s)://{endpoint}/{bucket}/{object}
+func (m *MinIOStore) URL(objectName string) string {
+	scheme := "http"
+	if conf.AppConfig.MinIO.UseSSL {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/%s/%s", scheme, conf.AppConfig.MinIO.Endpoint, m.bucketName, objectName)
+}
+
+// ObjectName 从存储地址中解析对象路径
+func (m *MinIOStore) ObjectName(storageURL string) string {
+	_, objectName := utils.ParseStorageURL(storageURL)
+	return objectName
+}
+
+// Client 获取底层MinIO客户端，供分片上传等MinIO特有功能使用
+func (m *MinIOStore) Client() *minio.Client {
+	return m.client
+}
+
+// BucketName 获取存储桶名称
+func (m *MinIOStore) BucketName() string {
+	return m.bucketName
+}
+
+// convertErr 将MinIO的对象不存在错误转换为 ErrObjectNotFound
+func (m *MinIOStore) convertErr(err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ErrObjectNotFound
+	}
+	return err
+}
+
+// toObjectInfo 转换MinIO对象信息
+func toObjectInfo(info minio.ObjectInfo) ObjectInfo {
+	return ObjectInfo{
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		ETag:         info.ETag,
+		LastModified: info.LastModified,
+	}
+}
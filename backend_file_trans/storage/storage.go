@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"daoke.com/file_trans/conf"
+	"daoke.com/file_trans/database"
+	"daoke.com/file_trans/logger"
+	"errors"
+	"io"
+	"log"
+	"time"
+)
+
+// 存储后端类型
+const (
+	TypeMinIO = "minio"
+	TypeLocal = "local"
+)
+
+// TrackQueryParam MinIO预签名直链中跟踪标识的查询参数名，存储后端忽略 x- 开头的参数，
+// 但会记录在审计日志中，用于关联下载链接
+const TrackQueryParam = "x-file-trans-track"
+
+// ErrObjectNotFound 对象不存在
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectInfo 对象元信息
+type ObjectInfo struct {
+	Size         int64     // 对象大小（字节）
+	ContentType  string    // Content-Type
+	ETag         string    // ETag
+	LastModified time.Time // 最后修改时间
+}
+
+// ObjectStore 对象存储接口，屏蔽MinIO与本地磁盘等存储后端的差异
+type ObjectStore interface {
+	// Put 写入对象，size 为 -1 时表示大小未知，返回实际写入的大小
+	Put(ctx context.Context, objectName string, reader io.Reader, size int64, contentType string) (int64, error)
+	// Get 读取对象，返回的对象支持Seek，可用于Range请求
+	Get(ctx context.Context, objectName string) (io.ReadSeekCloser, ObjectInfo, error)
+	// GetRange 读取对象从 offset 开始的 length 字节，用于识别文件头等只需少量数据的场景
+	GetRange(ctx context.Context, objectName string, offset, length int64) (io.ReadCloser, error)
+	// Stat 查询对象元信息，对象不存在时返回 ErrObjectNotFound
+	Stat(ctx context.Context, objectName string) (ObjectInfo, error)
+	// Delete 删除对象
+	Delete(ctx context.Context, objectName string) error
+	// PresignGet 生成有时效的下载链接，fileName 为下载时保存的文件名
+	PresignGet(ctx context.Context, objectName, fileName string, expiry time.Duration) (string, error)
+	// PresignTrackedGet 生成附带跟踪标识（参与签名）的下载链接，用于统计取件方的传输字节数，trackingID 为空时与 PresignGet 相同
+	PresignTrackedGet(ctx context.Context, objectName, fileName, trackingID string, expiry time.Duration) (string, error)
+	// URL 生成对象的存储地址（保存在 TransInfo.StorageUrl 中）
+	URL(objectName string) string
+	// ObjectName 从存储地址中解析对象路径
+	ObjectName(storageURL string) string
+}
+
+// Default 全局对象存储实例
+var Default ObjectStore
+
+// InitStorage 根据配置初始化存储后端
+func InitStorage() {
+	storageConf := conf.AppConfig.Storage
+	switch storageConf.Type {
+	case TypeLocal:
+		store, err := NewLocalStore(storageConf.Local)
+		if err != nil {
+			log.Fatalf("初始化本地存储失败：%v", err)
+		}
+		Default = store
+	default:
+		database.InitMinIO()
+		Default = NewMinIOStore(database.MinIOClient, conf.AppConfig.MinIO.BucketName)
+	}
+	logger.Info("存储后端初始化成功", "type", storageConf.Type)
+}
+
+// IsMinIO 当前是否使用MinIO存储后端（分片上传、预签名直传等功能依赖MinIO）
+func IsMinIO() bool {
+	_, ok := Default.(*MinIOStore)
+	return ok
+}
+
+// PresignStorageURL 根据分享记录中的存储地址生成有时效的下载链接
+// （内容相同的分享共用存储对象，下载文件名以分享记录为准，不使用对象名）
+func PresignStorageURL(ctx context.Context, storageURL, fileName string, expiry time.Duration) (string, error) {
+	return Default.PresignGet(ctx, Default.ObjectName(storageURL), fileName, expiry)
+}
+
+// PresignTrackedURL 根据分享记录中的存储地址生成附带跟踪标识的下载链接
+func PresignTrackedURL(ctx context.Context, storageURL, fileName, trackingID string, expiry time.Duration) (string, error) {
+	return Default.PresignTrackedGet(ctx, Default.ObjectName(storageURL), fileName, trackingID, expiry)
+}
+
+// DeleteStorageURL 根据分享记录中的存储地址删除对象
+func DeleteStorageURL(ctx context.Context, storageURL string) error {
+	return Default.Delete(ctx, Default.ObjectName(storageURL))
+}
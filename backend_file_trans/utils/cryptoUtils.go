@@ -0,0 +1,294 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// 分块加密格式：文件头（魔数 + 随机nonce前缀）后接若干密文块，每块明文 EncryptChunkSize 字节（最后一块可能不足），
+// 各块独立以 AES-256-GCM 加密，nonce 为 前缀(7) + 块序号(4) + 是否最后一块(1)，可防止块被重排或截断，
+// 固定的块大小使解密时可以直接定位到任意位置，支持Range请求
+const (
+	EncryptChunkSize = 64 * 1024 // 每块明文大小
+
+	encryptMagic      = "FTE1"
+	encryptPrefixSize = 7
+	encryptHeaderSize = len(encryptMagic) + encryptPrefixSize
+	encryptTagSize    = 16
+	encryptBlockSize  = EncryptChunkSize + encryptTagSize // 每块密文大小
+)
+
+// DataKeySize 数据密钥长度（AES-256）
+const DataKeySize = 32
+
+// ErrDecryptFailed 密钥错误或密文被篡改、截断
+var ErrDecryptFailed = errors.New("decrypt failed")
+
+// GenerateDataKey 生成随机数据密钥
+func GenerateDataKey() ([]byte, error) {
+	key := make([]byte, DataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// DeriveKey 以 HMAC-SHA256(secret, purpose + 各参数) 派生密钥，purpose 区分不同用途
+func DeriveKey(secret, purpose string, parts ...string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	for _, part := range parts {
+		mac.Write([]byte{0})
+		mac.Write([]byte(part))
+	}
+	return mac.Sum(nil)
+}
+
+// SealKey 使用包装密钥 kek 加密数据密钥等短数据（AES-256-GCM，aad 为附加认证数据），返回base64编码的密文
+func SealKey(kek, key []byte, aad string) (string, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, key, []byte(aad))), nil
+}
+
+// OpenKey 解密 SealKey 加密的数据，包装密钥或 aad 不匹配时返回 ErrDecryptFailed
+func OpenKey(kek []byte, sealed, aad string) ([]byte, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return nil, ErrDecryptFailed
+	}
+	key, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(aad))
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return key, nil
+}
+
+// EncryptedSize 计算明文加密后的大小，size 为 -1（大小未知）时返回 -1
+func EncryptedSize(size int64) int64 {
+	if size < 0 {
+		return -1
+	}
+	return int64(encryptHeaderSize) + size + chunkCount(size)*encryptTagSize
+}
+
+// chunkCount 明文对应的块数，空文件也有一个（空的）最后一块
+func chunkCount(size int64) int64 {
+	return max((size+EncryptChunkSize-1)/EncryptChunkSize, 1)
+}
+
+// NewEncryptReader 返回边读取明文边输出密文的 Reader
+func NewEncryptReader(key []byte, plaintext io.Reader) (io.Reader, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, encryptHeaderSize)
+	copy(header, encryptMagic)
+	if _, err := rand.Read(header[len(encryptMagic):]); err != nil {
+		return nil, err
+	}
+	return &encryptReader{
+		aead:   aead,
+		src:    plaintext,
+		prefix: header[len(encryptMagic):],
+		out:    header,
+		buf:    make([]byte, EncryptChunkSize+1),
+	}, nil
+}
+
+type encryptReader struct {
+	aead   cipher.AEAD
+	src    io.Reader
+	prefix []byte
+	out    []byte // 待输出的密文
+	buf    []byte // 读取的明文，多读1字节用于判断是否为最后一块
+	carry  int    // buf 中已预读的下一块明文字节数
+	chunk  uint32
+	done   bool
+}
+
+func (e *encryptReader) Read(p []byte) (int, error) {
+	for len(e.out) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		if err := e.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+// next 读取并加密下一块明文
+func (e *encryptReader) next() error {
+	n, err := io.ReadFull(e.src, e.buf[e.carry:])
+	n += e.carry
+	last := false
+	switch {
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		last = true
+	case err != nil:
+		return err
+	}
+
+	size := min(n, EncryptChunkSize)
+	e.out = e.aead.Seal(e.out[:0], chunkNonce(e.prefix, e.chunk, last), e.buf[:size], nil)
+	if last {
+		e.done = true
+		return nil
+	}
+	// 预读的1字节属于下一块
+	e.carry = copy(e.buf, e.buf[size:n])
+	e.chunk++
+	return nil
+}
+
+// NewDecryptReader 返回按块解密的 ReadSeeker 及明文大小，size 为密文（存储对象）大小；
+// 读取时只解密所需的块，支持 Seek
+func NewDecryptReader(key []byte, ciphertext io.ReadSeeker, size int64) (io.ReadSeeker, int64, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	body := size - int64(encryptHeaderSize)
+	if body < encryptTagSize {
+		return nil, 0, ErrDecryptFailed
+	}
+	header := make([]byte, encryptHeaderSize)
+	if _, err := io.ReadFull(ciphertext, header); err != nil {
+		return nil, 0, err
+	}
+	if !bytes.Equal(header[:len(encryptMagic)], []byte(encryptMagic)) {
+		return nil, 0, ErrDecryptFailed
+	}
+
+	chunks := (body + encryptBlockSize - 1) / encryptBlockSize
+	plainSize := body - chunks*encryptTagSize
+	if plainSize < 0 || chunkCount(plainSize) != chunks {
+		return nil, 0, ErrDecryptFailed
+	}
+	return &decryptReader{
+		aead:     aead,
+		src:      ciphertext,
+		srcPos:   int64(encryptHeaderSize),
+		prefix:   header[len(encryptMagic):],
+		size:     plainSize,
+		chunks:   chunks,
+		block:    make([]byte, encryptBlockSize),
+		bufChunk: -1,
+	}, plainSize, nil
+}
+
+type decryptReader struct {
+	aead     cipher.AEAD
+	src      io.ReadSeeker
+	srcPos   int64 // 密文当前读取位置
+	prefix   []byte
+	size     int64 // 明文大小
+	chunks   int64
+	offset   int64  // 明文当前位置
+	block    []byte // 密文块缓冲
+	buf      []byte // 已解密的块
+	bufChunk int64  // buf 对应的块序号
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	if d.offset >= d.size {
+		return 0, io.EOF
+	}
+	chunk := d.offset / EncryptChunkSize
+	if chunk != d.bufChunk {
+		if err := d.load(chunk); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf[d.offset-chunk*EncryptChunkSize:])
+	d.offset += int64(n)
+	return n, nil
+}
+
+// load 读取并解密指定的块，顺序读取时无需 Seek 存储对象
+func (d *decryptReader) load(chunk int64) error {
+	pos := int64(encryptHeaderSize) + chunk*encryptBlockSize
+	if pos != d.srcPos {
+		if _, err := d.src.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+		d.srcPos = pos
+	}
+	plain := min(d.size-chunk*EncryptChunkSize, EncryptChunkSize)
+	block := d.block[:plain+encryptTagSize]
+	n, err := io.ReadFull(d.src, block)
+	d.srcPos += int64(n)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrDecryptFailed
+		}
+		return err
+	}
+
+	buf, err := d.aead.Open(d.buf[:0], chunkNonce(d.prefix, uint32(chunk), chunk == d.chunks-1), block, nil)
+	if err != nil {
+		d.bufChunk = -1
+		return ErrDecryptFailed
+	}
+	d.buf, d.bufChunk = buf, chunk
+	return nil
+}
+
+func (d *decryptReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.offset
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	d.offset = offset
+	return offset, nil
+}
+
+// chunkNonce 生成块的nonce：前缀(7) + 块序号(4，大端) + 是否最后一块(1)
+func chunkNonce(prefix []byte, chunk uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptPrefixSize:], chunk)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
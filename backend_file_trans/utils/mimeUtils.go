@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"github.com/gabriel-vasile/mimetype"
+	"io"
+	"mime"
+	"strings"
+)
+
+// SniffLength 识别内容类型时读取的字节数（与 mimetype 默认读取上限一致）
+const SniffLength = 3072
+
+// 文件分类，供前端显示图标及判断能否预览
+const (
+	CategoryImage    = "image"
+	CategoryVideo    = "video"
+	CategoryAudio    = "audio"
+	CategoryText     = "text"
+	CategoryPDF      = "pdf"
+	CategoryDocument = "document"
+	CategoryArchive  = "archive"
+	CategoryOther    = "other"
+)
+
+// archiveTypes 归为压缩包的MIME类型
+var archiveTypes = map[string]bool{
+	"application/zip":              true,
+	"application/gzip":             true,
+	"application/x-tar":            true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/zstd":             true,
+}
+
+// SniffContentType 读取数据开头的若干字节，根据文件头识别内容类型，
+// 返回识别结果及仍可从头读取完整数据的reader
+func SniffContentType(reader io.Reader) (string, io.Reader, error) {
+	head := make([]byte, SniffLength)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", nil, err
+	}
+	head = head[:n]
+	return mimetype.Detect(head).String(), io.MultiReader(bytes.NewReader(head), reader), nil
+}
+
+// FileCategory 根据MIME类型归类文件
+func FileCategory(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return CategoryOther
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		return CategoryImage
+	case strings.HasPrefix(mediaType, "video/"):
+		return CategoryVideo
+	case strings.HasPrefix(mediaType, "audio/"):
+		return CategoryAudio
+	case mediaType == "application/pdf":
+		return CategoryPDF
+	case archiveTypes[mediaType]:
+		return CategoryArchive
+	case strings.HasPrefix(mediaType, "text/"), mediaType == "application/json", mediaType == "application/xml",
+		mediaType == "application/javascript", mediaType == "application/x-sh":
+		return CategoryText
+	case mediaType == "application/msword", mediaType == "application/rtf",
+		strings.HasPrefix(mediaType, "application/vnd.openxmlformats-officedocument."),
+		strings.HasPrefix(mediaType, "application/vnd.oasis.opendocument."),
+		strings.HasPrefix(mediaType, "application/vnd.ms-"):
+		return CategoryDocument
+	default:
+		return CategoryOther
+	}
+}
@@ -2,32 +2,43 @@ package utils
 
 import (
 	"context"
-	"daoke.com/file_trans/database"
+	"github.com/minio/minio-go/v7"
 	"net/url"
 	"strings"
 	"time"
 )
 
 /*
-GeneratePreSignedDownloadURL 生成MinIO文件的预签名下载链接
-bucketName: MinIO存储桶名称
-objectName: 存储在MinIO中的对象路径（如"files/12345_logo.png"）
-expiry: 链接过期时间（如15分钟）
+GeneratePreSignedUploadPolicy 生成MinIO的预签名POST上传策略，客户端直接上传到对象存储
+objectName: 允许上传的对象路径（策略限定只能写入该路径）
+fileSize: 允许上传的文件大小（策略限定上传内容必须恰好为该大小）
+contentType: 允许的Content-Type
+expiry: 策略有效期
+返回上传地址及客户端需要随文件一起提交的表单字段
 */
-func GeneratePreSignedDownloadURL(bucketName, objectName string, expiry time.Duration) (string, error) {
-	ctx := context.Background()
-	// 生成预签名URL（包含临时访问凭证）
-	preSignedURL, err := database.MinIOClient.PresignedGetObject(
-		ctx,
-		bucketName,
-		objectName,
-		expiry,
-		url.Values{}, // 额外请求参数（可选）
-	)
+func GeneratePreSignedUploadPolicy(client *minio.Client, bucketName, objectName string, fileSize int64, contentType string, expiry time.Duration) (string, map[string]string, error) {
+	policy := minio.NewPostPolicy()
+	if err := policy.SetBucket(bucketName); err != nil {
+		return "", nil, err
+	}
+	if err := policy.SetKey(objectName); err != nil {
+		return "", nil, err
+	}
+	if err := policy.SetExpires(time.Now().UTC().Add(expiry)); err != nil {
+		return "", nil, err
+	}
+	if err := policy.SetContentType(contentType); err != nil {
+		return "", nil, err
+	}
+	if err := policy.SetContentLengthRange(fileSize, fileSize); err != nil {
+		return "", nil, err
+	}
+
+	uploadURL, formData, err := client.PresignedPostPolicy(context.Background(), policy)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
-	return preSignedURL.String(), nil
+	return uploadURL.String(), formData, nil
 }
 
 // 从StorageUrl中解析bucket和object名称
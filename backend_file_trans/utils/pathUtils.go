@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// maxEntryPathLength 条目相对路径的最大长度（字节），超出时只保留文件名
+const maxEntryPathLength = 1024
+
+// SanitizeEntryPath 清理客户端传入的相对路径，用作压缩包及文件清单中的条目名称：
+// 统一使用 / 分隔，去掉开头的 /、空目录、. 和 ..，替换控制字符及 Windows 不允许的字符，
+// 保证结果不会指向分享目录之外；清理后为空时返回空字符串
+func SanitizeEntryPath(name string) string {
+	segments := strings.Split(strings.ReplaceAll(name, "\\", "/"), "/")
+	cleaned := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		segment = strings.Map(func(r rune) rune {
+			if r < 0x20 || r == 0x7f {
+				return -1
+			}
+			if strings.ContainsRune(`:*?"<>|`, r) {
+				return '_'
+			}
+			return r
+		}, segment)
+		// Windows 会忽略结尾的点和空格，去掉以免解压后与其他条目重名
+		segment = strings.TrimRight(strings.TrimSpace(segment), ". ")
+		if segment == "" {
+			continue
+		}
+		cleaned = append(cleaned, segment)
+	}
+	if len(cleaned) == 0 {
+		return ""
+	}
+
+	entryPath := strings.Join(cleaned, "/")
+	if len(entryPath) > maxEntryPathLength {
+		return cleaned[len(cleaned)-1]
+	}
+	return entryPath
+}
+
+// EntryNamer 为同一个压缩包中的条目分配不重复的名称
+type EntryNamer struct {
+	used map[string]struct{} // 已占用的文件及目录路径（小写）
+}
+
+// NewEntryNamer 创建一个新的 EntryNamer 实例
+func NewEntryNamer() *EntryNamer {
+	return &EntryNamer{used: make(map[string]struct{})}
+}
+
+// Unique 返回不与已有文件或目录重名的条目名称（忽略大小写，避免在不区分大小写的文件系统上解压时覆盖），
+// 重名时在文件名后追加序号，如 a.txt、a (1).txt
+func (n *EntryNamer) Unique(entryPath string) string {
+	candidate := entryPath
+	for i := 1; n.taken(candidate); i++ {
+		candidate = withSequence(entryPath, i)
+	}
+
+	n.used[strings.ToLower(candidate)] = struct{}{}
+	for dir := path.Dir(candidate); dir != "."; dir = path.Dir(dir) {
+		n.used[strings.ToLower(dir)] = struct{}{}
+	}
+	return candidate
+}
+
+// taken 判断名称是否已被占用
+func (n *EntryNamer) taken(entryPath string) bool {
+	_, ok := n.used[strings.ToLower(entryPath)]
+	return ok
+}
+
+// withSequence 在文件名（扩展名之前）追加序号
+func withSequence(entryPath string, seq int) string {
+	dir, base := path.Split(entryPath)
+	ext := path.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	if stem == "" {
+		// 以点开头的文件（如 .gitignore）整体视为文件名
+		stem, ext = base, ""
+	}
+	return fmt.Sprintf("%s%s (%d)%s", dir, stem, seq, ext)
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// GeneratePickupCode 使用加密安全的随机数，从字符集中生成指定长度的取件码
+func GeneratePickupCode(length int, alphabet string) (string, error) {
+	if length <= 0 || alphabet == "" {
+		return "", errors.New("invalid pickupCode length or alphabet")
+	}
+
+	chars := []rune(alphabet)
+	max := big.NewInt(int64(len(chars)))
+
+	var sb strings.Builder
+	for i := 0; i < length; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteRune(chars[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+// ValidatePickupCode 校验取件码长度以及是否只包含字符集中的字符
+func ValidatePickupCode(code string, length int, alphabet string) bool {
+	chars := []rune(code)
+	if len(chars) != length {
+		return false
+	}
+	for _, c := range chars {
+		if !strings.ContainsRune(alphabet, c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// GenerateToken 使用加密安全的随机数生成 URL 安全的令牌（32字节熵）
+func GenerateToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// ValidateToken 校验令牌是否为 GenerateToken 生成的格式
+func ValidateToken(token string) bool {
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	return err == nil && len(buf) == 32
+}
+
+// HashToken 计算令牌的SHA-256哈希（十六进制），数据库中只保存哈希
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckToken 以常量时间比较令牌与哈希是否匹配
+func CheckToken(hash, token string) bool {
+	if hash == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(HashToken(token))) == 1
+}
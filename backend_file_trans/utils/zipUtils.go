@@ -2,32 +2,42 @@ package utils
 
 import (
 	"archive/zip"
-	"bytes"
 	"io"
 )
 
-// CompressFiles 将多个文件压缩成zip包
-func CompressFiles(files map[string]io.Reader) (io.Reader, int64, error) {
-	buf := new(bytes.Buffer)
-	zw := zip.NewWriter(buf)
+// ZipEntry 压缩包中的一个文件，写入该条目时才打开文件内容
+type ZipEntry struct {
+	Name string
+	Open func() (io.ReadCloser, error)
+}
 
-	for fileName, reader := range files {
-		// 创建zip文件中的文件条目
-		f, err := zw.Create(fileName)
-		if err != nil {
-			return nil, 0, err
-		}
+// CompressFiles 按顺序将多个文件压缩成zip包，边压缩边写入w，不在内存中缓冲整个压缩包，
+// 同一时刻只打开一个文件
+func CompressFiles(w io.Writer, entries []ZipEntry) error {
+	zw := zip.NewWriter(w)
 
-		// 将文件内容写入zip
-		if _, err := io.Copy(f, reader); err != nil {
-			return nil, 0, err
+	for _, entry := range entries {
+		if err := writeZipEntry(zw, entry); err != nil {
+			return err
 		}
 	}
 
-	// 关闭zip writer
-	if err := zw.Close(); err != nil {
-		return nil, 0, err
+	// 关闭zip writer，写入中央目录
+	return zw.Close()
+}
+
+// writeZipEntry 创建zip文件条目并写入文件内容
+func writeZipEntry(zw *zip.Writer, entry ZipEntry) error {
+	reader, err := entry.Open()
+	if err != nil {
+		return err
 	}
+	defer reader.Close()
 
-	return bytes.NewReader(buf.Bytes()), int64(buf.Len()), nil
+	f, err := zw.Create(entry.Name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, reader)
+	return err
 }
@@ -1,6 +1,9 @@
 import { createApp } from 'vue'
 import App from './App.vue'
 import router from './router'
+import { setupDeviceId } from './utils/deviceId'
+
+setupDeviceId()
 
 const app = createApp(App)
 app.use(router)
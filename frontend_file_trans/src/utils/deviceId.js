@@ -0,0 +1,30 @@
+// src/utils/deviceId.js
+import axios from 'axios'
+
+const DEVICE_ID_KEY = 'deviceId'
+const DEVICE_ID_HEADER = 'X-Device-Id'
+
+// 为全局 axios 注册设备标识拦截器：请求时携带后端签发的设备标识，响应中有新标识时保存
+export function setupDeviceId() {
+	axios.interceptors.request.use(config => {
+		const deviceId = localStorage.getItem(DEVICE_ID_KEY)
+		if (deviceId) {
+			config.headers[DEVICE_ID_HEADER] = deviceId
+		}
+		return config
+	})
+
+	const saveDeviceId = response => {
+		const deviceId = response?.headers?.[DEVICE_ID_HEADER.toLowerCase()]
+		if (deviceId && deviceId !== localStorage.getItem(DEVICE_ID_KEY)) {
+			localStorage.setItem(DEVICE_ID_KEY, deviceId)
+		}
+	}
+	axios.interceptors.response.use(response => {
+		saveDeviceId(response)
+		return response
+	}, error => {
+		saveDeviceId(error.response)
+		return Promise.reject(error)
+	})
+}